@@ -5,9 +5,7 @@ go 1.23.0
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
 	github.com/catppuccin/go v0.2.0
+	golang.org/x/net v0.38.0
 )
 
-require (
-	github.com/andybalholm/cascadia v1.3.1 // indirect
-	golang.org/x/net v0.38.0 // indirect
-)
+require github.com/andybalholm/cascadia v1.3.1 // indirect
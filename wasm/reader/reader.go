@@ -1,90 +1,815 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"html"
+	"html/template"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall/js"
+	texttemplate "text/template"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
 	catppuccin "github.com/catppuccin/go"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 // Config holds application configuration
 type Config struct {
-	RequestTimeout time.Duration
-	MaxContentSize int64
-	UserAgent      string
+	RequestTimeout time.Duration `json:"-"`
+	MaxContentSize int64         `json:"-"`
+	UserAgent      string        `json:"-"`
+
+	// LargeContentThreshold is a soft limit in bytes above which results
+	// are flagged with largeContent, well before MaxContentSize rejects them
+	LargeContentThreshold int64 `json:"largeContentThreshold"`
+
+	// Transport tunables for the connection pool shared across calls.
+	// Options cannot set them: under js/wasm requests go through the
+	// runtime's fetch, which manages its own connections
+	MaxIdleConns        int           `json:"-"`
+	MaxIdleConnsPerHost int           `json:"-"`
+	IdleConnTimeout     time.Duration `json:"-"`
+
+	// ConnectTimeout bounds the wait for response headers so unreachable
+	// hosts fail fast, while RequestTimeout still covers the whole download.
+	// Both are set from options in milliseconds
+	ConnectTimeout time.Duration `json:"-"`
+
+	// ProcessingTimeout bounds the parsing, cleaning and extraction after
+	// the download, failing with processing_timeout once exceeded; zero
+	// disables it. Set from options in milliseconds
+	ProcessingTimeout time.Duration `json:"-"`
+
+	// PrecheckHead sends a HEAD request first so wrong-typed or oversized
+	// resources are rejected without downloading them
+	PrecheckHead bool `json:"precheckHead"`
+
+	// RedirectHeaders controls which request headers follow redirects:
+	// "same-origin" (default) drops all but the safe headers once a
+	// redirect leaves the original origin, "safe" always does, and "all"
+	// forwards everything
+	RedirectHeaders string `json:"redirectHeaders"`
+
+	// PreferAlternate extracts content from the page's print-friendly or
+	// AMP alternate when it links one on the same site, reporting the
+	// version used. Metadata still comes from the original page
+	PreferAlternate bool `json:"preferAlternate"`
+
+	// AMPFallback extracts from the page's same-site AMP version when the
+	// page itself yields next to no content, as JavaScript-rendered shells
+	// do, reporting the version used
+	AMPFallback bool `json:"ampFallback"`
+
+	// Accept is sent as the request Accept header and forwarded on redirects
+	Accept string `json:"accept"`
+
+	// Mode selects the extraction strategy: "article" (default), "forum"
+	// for discussion threads rendered with reply indentation, "recipe"
+	// for schema.org Recipe data without the surrounding narrative, or
+	// "list" for index pages made of several <article> elements
+	Mode string `json:"mode"`
+
+	// Strength is a cleaning preset: "minimal" only removes scripts and
+	// styles, "balanced" (default) is the standard cleaning and
+	// "aggressive" also strips anything resembling boilerplate. It sets the
+	// removal flags below, which explicit options still override
+	Strength string `json:"strength"`
+
+	// RemoveChrome removes page chrome (navigation, headers, footers,
+	// asides, ads, comments, popups, app banners) before content scoring
+	RemoveChrome bool `json:"removeChrome"`
+
+	// AriaHidden controls removal of aria-hidden="true" elements with
+	// RemoveChrome: "smart" (default) removes only small or decorative
+	// ones, keeping large text blocks such as SPA content hidden until
+	// hydration, "remove" removes all and "keep" none
+	AriaHidden string `json:"ariaHidden"`
+
+	// Modals controls removal of dialogs, modals, popups and overlays with
+	// RemoveChrome: "smart" (default) keeps one that holds most of the
+	// page's text, as some sites serve the article in a modal, "remove"
+	// removes all and "keep" none
+	Modals string `json:"modals"`
+
+	// ExcludeSelectors removes matching regions before content scoring
+	ExcludeSelectors []string `json:"excludeSelectors"`
+
+	// ContentSelectors are tried in order before content scoring; the first
+	// with enough text is used as the main content. With
+	// ReplaceContentSelectors the default scored selectors are skipped
+	ContentSelectors        []string `json:"contentSelectors"`
+	ReplaceContentSelectors bool     `json:"replaceContentSelectors"`
+
+	// Scoring tunes how candidate content containers are compared; the
+	// defaults reproduce the built-in scoring
+	Scoring ScoringWeights `json:"scoring"`
+
+	// Format selects the output: "html" (default), "json", "rss", "atom",
+	// "html5", an unstyled semantic document for archiving, or "email",
+	// plain text with numbered link references
+	Format string `json:"format"`
+
+	// Formats renders several output formats from a single extraction,
+	// overriding Format; each format adds its own result keys
+	Formats []string `json:"formats"`
+
+	// WrapWidth is the column at which "email" output is hard-wrapped
+	WrapWidth int `json:"wrapWidth"`
+
+	// PreviewLength is the target character length of the preview teaser
+	PreviewLength int `json:"previewLength"`
+
+	// AnnotateLinks marks off-site content links with data-external="true";
+	// ExternalLinkMarker additionally shows an arrow after them
+	AnnotateLinks      bool `json:"annotateLinks"`
+	ExternalLinkMarker bool `json:"externalLinkMarker"`
+
+	// AllowedImageTypes lists the image extensions or MIME types that may
+	// load; other images are replaced with a link. Empty allows everything
+	AllowedImageTypes []string `json:"allowedImageTypes"`
+
+	// SameOriginImages replaces images hosted off the article's site with a
+	// link to the image, so displaying the page makes no third-party requests
+	SameOriginImages bool `json:"sameOriginImages"`
+
+	// ProbeImageSize fetches the start of the lead image to read its
+	// dimensions when the page does not declare them
+	ProbeImageSize bool `json:"probeImageSize"`
+
+	// StripImageParams removes the named query parameters, such as
+	// cache-busters, from content image URLs; "*" removes the whole query.
+	// Signed image URLs stop working once their signature is stripped
+	StripImageParams []string `json:"stripImageParams"`
+
+	// InlineFrames fetches same-origin iframes and merges their main
+	// content into the page, for sites that frame the article itself
+	InlineFrames bool `json:"inlineFrames"`
+
+	// Linkify wraps bare http(s) URLs in text with links
+	Linkify bool `json:"linkify"`
+
+	// FootnoteTooltips shows each footnote's text as a hover tooltip on
+	// its reference marker
+	FootnoteTooltips bool `json:"footnoteTooltips"`
+
+	// ExpandAbbreviations shows the title of each abbreviation's first use
+	// inline after it on touch devices, where the tooltip cannot be hovered
+	ExpandAbbreviations bool `json:"expandAbbreviations"`
+
+	// Typography normalizes quotes, dashes and ellipses in text outside
+	// code: "typographic" converts to curly quotes, em and en dashes and
+	// the ellipsis character, "ascii" to their plain ASCII forms
+	Typography string `json:"typography"`
+
+	// Highlights are phrases marked wherever they occur in the content
+	// text, matched case-insensitively outside code
+	Highlights []string `json:"highlights"`
+
+	// Template overrides the default page with a caller-supplied
+	// html/template; fields of pageData are available to it
+	Template string `json:"template"`
+
+	// Concurrency caps in-flight fetches for processReaderBatch
+	Concurrency int `json:"concurrency"`
+
+	// Fragment renders only the .reader-container element instead of a full
+	// document. FragmentStyles is "scoped" (default) to prepend a <style>
+	// block scoped to the container, or "none" to omit styles
+	Fragment       bool   `json:"fragment"`
+	FragmentStyles string `json:"fragmentStyles"`
+
+	// IncludePlainText adds the markup-free article body under plainText,
+	// with paragraphs separated by blank lines, for NLP consumers
+	IncludePlainText bool `json:"includePlainText"`
+
+	// DeriveKeywords fills keywords from the most frequent significant
+	// words of the body when the page declares none
+	DeriveKeywords bool `json:"deriveKeywords"`
+
+	// Sentiment adds a best-effort, lexicon-based tone score of the body
+	// prose under sentiment in JSON output. Only English is scored
+	Sentiment bool `json:"sentiment"`
+
+	// CollectImages lists the substantial content images, with captions,
+	// under images in JSON output
+	CollectImages bool `json:"collectImages"`
+
+	// Segments wraps each sentence of the content in a span with a
+	// data-segment-id and returns the sentences under segments, for
+	// read-aloud highlighting
+	Segments bool `json:"segments"`
+
+	// TableOfContents returns the content's headings under toc, each with
+	// the word count of its section for reading-progress estimates
+	TableOfContents bool `json:"toc"`
+
+	// Reviews appends a summary of the page's schema.org AggregateRating
+	// and Review data below the article, for product review pages
+	Reviews bool `json:"reviews"`
+
+	// MaxHeadingDepth is the deepest heading level kept (1-6, default 6);
+	// deeper headings are flattened into bold paragraphs, so they leave
+	// the table of contents too
+	MaxHeadingDepth int `json:"maxHeadingDepth"`
+
+	// IncludeRawHTML adds the fetched source under rawHtml, after encoding
+	// cleanup, for debugging extraction
+	IncludeRawHTML bool `json:"includeRawHtml"`
+
+	// MaxWords truncates the rendered page at the paragraph boundary
+	// nearest this many words and links to the original; 0 disables it
+	MaxWords int `json:"maxWords"`
+
+	// StripDuplicateTitle removes a leading content heading that repeats
+	// the title, which the page header already shows
+	StripDuplicateTitle bool `json:"stripDuplicateTitle"`
+
+	// TitleLevel is the heading level (1-3) of the rendered title, so
+	// embedded fragments can sit below the host page's own h1
+	TitleLevel int `json:"titleLevel"`
+
+	// UILanguage selects the language of the reader's own strings, such as
+	// the byline and the source link: "en" (default), "de", "es", "fr",
+	// "it", "nl" or "pt". Regional tags fall back to their base language
+	// and unknown ones to English
+	UILanguage string `json:"uiLanguage"`
+
+	// ShowSection renders the extracted section as a badge in the header
+	ShowSection bool `json:"showSection"`
+
+	// ShowBreadcrumbs renders the extracted breadcrumb trail above the title
+	ShowBreadcrumbs bool `json:"showBreadcrumbs"`
+
+	// Flavor selects the Catppuccin flavour: latte, frappe, macchiato or
+	// mocha (default)
+	Flavor string `json:"flavor"`
+
+	// DimImages slightly darkens images on dark flavours, restoring them on
+	// hover; ImageBrightness is the CSS brightness() factor used
+	DimImages       bool    `json:"dimImages"`
+	ImageBrightness float64 `json:"imageBrightness"`
+
+	// StyleMode "external" omits the inline <style> block for strict CSPs,
+	// returning the CSS under "css" and linking StylesheetURL when set.
+	// "original" links the source page's own stylesheets instead of the
+	// theme. StyleNonce adds a CSP nonce to the inline <style> block
+	StyleMode     string `json:"styleMode"`
+	StylesheetURL string `json:"stylesheetUrl"`
+	StyleNonce    string `json:"styleNonce"`
+
+	// Fonts "google" (default) loads the theme fonts from Google Fonts;
+	// "embedded" inlines bundled Open Sans and Source Code Pro as base64
+	// @font-face rules so the page renders offline without third-party
+	// requests
+	Fonts string `json:"fonts"`
+
+	// MergeBrokenLines rejoins sentences broken by hard line breaks in
+	// PDF-derived content. Aggressive, so off by default
+	MergeBrokenLines bool `json:"mergeLines"`
+
+	// BreakParagraphs turns runs of two or more <br> into paragraph
+	// breaks, for sites that separate paragraphs that way. Single <br>
+	// line breaks are kept
+	BreakParagraphs bool `json:"breakParagraphs"`
+
+	// MinImageSize removes images declared narrower or shorter than this
+	// many pixels (tracking pixels, spacers); 0 disables the filter
+	MinImageSize int `json:"minImageSize"`
+
+	// FuzzyRemoval enables removing small elements whose text mentions
+	// advertising, sponsorship or cookie consent. Selector-based removal
+	// is unaffected when disabled
+	FuzzyRemoval bool `json:"fuzzyRemoval"`
+
+	// BoilerplatePhrases extends the phrases that mark a small element as
+	// advertising or boilerplate for FuzzyRemoval; matching ignores case
+	BoilerplatePhrases []string `json:"boilerplatePhrases"`
+
+	// Canonical normalizes the content markup (sorted attributes, collapsed
+	// whitespace, no comments) so identical content serializes identically
+	Canonical bool `json:"canonical"`
+
+	// PullQuotes handles magazine pull quotes, which repeat body text:
+	// "dedupe" (default) drops those whose text appears in the body and
+	// styles the rest, "style" styles all of them and "remove" drops them
+	PullQuotes string `json:"pullQuotes"`
+
+	// KeepForms keeps forms and their controls, for interactive
+	// documentation; by default they are removed from the content
+	KeepForms bool `json:"keepForms"`
+
+	// LabelSponsored keeps elements matching a boilerplate phrase and
+	// labels them as sponsored instead of removing them; cookie prompts
+	// are still removed
+	LabelSponsored bool `json:"labelSponsored"`
+
+	// RemoveLinkClusters drops dense blocks of short links, such as
+	// "related articles" rails, from the extracted content
+	RemoveLinkClusters bool `json:"removeLinkClusters"`
+
+	// TrimEdges crops boilerplate blocks, such as newsletter sign-ups and
+	// author bios, from the start and end of the extracted content.
+	// EdgePhrases extends the phrases that mark such a block
+	TrimEdges   bool     `json:"trimEdges"`
+	EdgePhrases []string `json:"edgePhrases"`
+
+	// ExpandClamped removes inline line-clamp, max-height and overflow
+	// styles that visually truncate text present in full in the markup
+	ExpandClamped bool `json:"expandClamped"`
+
+	// SVGMode controls inline SVG handling: "auto" (default) drops
+	// icon-sized graphics and keeps diagrams, "keep" and "strip" are absolute
+	SVGMode string `json:"svg"`
+}
+
+// ReaderError is a processing failure with a machine-readable code
+type ReaderError struct {
+	Code    string
+	Message string
+
+	// Details are extra machine-readable fields added to the error result
+	Details map[string]interface{}
+}
+
+func (e *ReaderError) Error() string {
+	return e.Message
+}
+
+// Article holds the extracted metadata and content of a page
+type Article struct {
+	URL          string      `json:"url"`
+	Title        string      `json:"title"`
+	Authors      []string    `json:"authors,omitempty"`
+	AuthorURL    string      `json:"authorUrl,omitempty"`
+	AuthorImage  string      `json:"authorImage,omitempty"`
+	PublishDate  string      `json:"publishDate,omitempty"`
+	Freshness    string      `json:"freshness,omitempty"`
+	ModifiedDate string      `json:"modifiedDate,omitempty"`
+	Description  string      `json:"description,omitempty"`
+	Preview      string      `json:"preview,omitempty"`
+	Section      string      `json:"section,omitempty"`
+	Breadcrumbs  []Crumb     `json:"breadcrumbs,omitempty"`
+	Language     string      `json:"language,omitempty"`
+	Direction    string      `json:"direction"`
+	ContentHash  string      `json:"contentHash"`
+	ArticleID    string      `json:"articleId"`
+	Domain       string      `json:"domain,omitempty"`
+	Logo         string      `json:"logo,omitempty"`
+	ThemeColor   *ThemeColor `json:"themeColor,omitempty"`
+	LeadImage    *LeadImage  `json:"leadImage,omitempty"`
+	Engagement   *Engagement `json:"engagement,omitempty"`
+	Images       []Image     `json:"images,omitempty"`
+	Keywords     []string    `json:"keywords,omitempty"`
+	Sentiment    *Sentiment  `json:"sentiment,omitempty"`
+	Location     *Location   `json:"location,omitempty"`
+	Sponsored    bool        `json:"sponsored,omitempty"`
+	Entries      []ListEntry `json:"entries,omitempty"`
+	Stylesheets  []string    `json:"-"`
+	Content      string      `json:"content"`
+}
+
+// Image is a content image collected for the gallery
+type Image struct {
+	URL     string `json:"url"`
+	Alt     string `json:"alt,omitempty"`
+	Caption string `json:"caption,omitempty"`
+}
+
+// LeadImage is the article's primary image. The dimensions and aspect
+// ratio are omitted when unknown
+type LeadImage struct {
+	URL         string  `json:"url"`
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	AspectRatio float64 `json:"aspectRatio,omitempty"`
+}
+
+// Sentiment is the estimated tone of the article. Score runs from -1 (all
+// negative) to 1 (all positive); Label is "positive", "neutral" or
+// "negative"
+type Sentiment struct {
+	Score float64 `json:"score"`
+	Label string  `json:"label"`
+}
+
+// ScoringWeights tune content scoring. A candidate container scores
+//
+//	(textLength/TextLengthDivisor + paragraphs*Paragraph + commas*Comma)
+//	* selectorWeight * (1 - LinkDensityPenalty*linkDensity)
+//
+// where the selector weight is Landmark for main and [role='main'],
+// Article for article and [role='article'], Document for
+// [role='document'] and Generic for the class, id and section matches.
+// Link density is the share of the text inside links
+type ScoringWeights struct {
+	Landmark           float64 `json:"landmark"`
+	Article            float64 `json:"article"`
+	Document           float64 `json:"document"`
+	Generic            float64 `json:"generic"`
+	Paragraph          float64 `json:"paragraph"`
+	Comma              float64 `json:"comma"`
+	LinkDensityPenalty float64 `json:"linkDensityPenalty"`
+	TextLengthDivisor  float64 `json:"textLengthDivisor"`
+}
+
+// score scores a candidate matched by a selector of the given weight
+func (w ScoringWeights) score(s *goquery.Selection, length int, weight float64) float64 {
+	divisor := w.TextLengthDivisor
+	if divisor <= 0 {
+		divisor = 1
+	}
+	score := float64(length) / divisor
+	if w.Paragraph != 0 {
+		score += float64(s.Find("p").Length()) * w.Paragraph
+	}
+	if w.Comma != 0 {
+		score += float64(strings.Count(s.Text(), ",")) * w.Comma
+	}
+	if w.LinkDensityPenalty != 0 && length > 0 {
+		density := float64(len(s.Find("a").Text())) / float64(length)
+		score *= max(0, 1-w.LinkDensityPenalty*density)
+	}
+	return score * weight
+}
+
+// ThemeColor is the page's declared browser UI color, as written in its
+// theme-color meta tags. Light and Dark hold the colors declared for
+// prefers-color-scheme media queries
+type ThemeColor struct {
+	Color string `json:"color"`
+	Light string `json:"light,omitempty"`
+	Dark  string `json:"dark,omitempty"`
+}
+
+// Crumb is one step of a breadcrumb trail
+type Crumb struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+// ListEntry is one article of a listing page
+type ListEntry struct {
+	Title   string `json:"title"`
+	URL     string `json:"url,omitempty"`
+	Excerpt string `json:"excerpt,omitempty"`
+	Content string `json:"content"`
+}
+
+// Location is the place an article covers or was reported from. The
+// coordinates are either both present or both absent
+type Location struct {
+	Name      string   `json:"name,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// TOCEntry is a heading of the content. Words counts the words between
+// the heading and the next heading of any level
+type TOCEntry struct {
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+	Level int    `json:"level"`
+	Words int    `json:"words"`
+}
+
+// Segment is a sentence of the content, matching the data-segment-id of
+// its spans in the content HTML
+type Segment struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// Engagement holds engagement counts published by the page; zero means
+// the count was not found
+type Engagement struct {
+	Comments int `json:"comments,omitempty"`
+	Likes    int `json:"likes,omitempty"`
+	Shares   int `json:"shares,omitempty"`
+	Views    int `json:"views,omitempty"`
 }
 
 // LoadConfig returns default configuration for WASM
 func LoadConfig() *Config {
 	return &Config{
 		RequestTimeout: 30 * time.Second,
+		ConnectTimeout: 10 * time.Second,
 		MaxContentSize: 10 * 1024 * 1024, // 10MB
 		UserAgent:      "Go-Reader/1.0 (+https://github.com/your-username/go-reader)",
+
+		LargeContentThreshold: 2 * 1024 * 1024, // 2MB
+
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+
+		Accept:              "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		RedirectHeaders:     "same-origin",
+		Mode:                "article",
+		TitleLevel:          1,
+		StripDuplicateTitle: true,
+		Format:              "html",
+		WrapWidth:           72,
+		Concurrency:         4,
+		FragmentStyles:      "scoped",
+		Flavor:              "mocha",
+		ImageBrightness:     0.9,
+		StyleMode:           "inline",
+		MinImageSize:        50,
+		AllowedImageTypes:   []string{"jpg", "jpeg", "png", "gif", "webp", "avif", "bmp"},
+		RemoveChrome:        true,
+		AriaHidden:          "smart",
+		Scoring: ScoringWeights{
+			Landmark: 1.5, Article: 1.2, Document: 1.1, Generic: 1,
+			TextLengthDivisor: 1,
+		},
+		UILanguage:         "en",
+		Modals:             "smart",
+		Fonts:              "google",
+		MaxHeadingDepth:    6,
+		FuzzyRemoval:       true,
+		RemoveLinkClusters: true,
+		ExpandClamped:      true,
+		SVGMode:            "auto",
+		PullQuotes:         "dedupe",
+		PreviewLength:      200,
 	}
 }
 
-// processURL fetches and processes a URL, returning readable HTML
-func processURL(targetURL string) (string, error) {
-	config := LoadConfig()
+// transportKey identifies a transport by its tunables
+type transportKey struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[transportKey]*http.Transport)
+)
+
+// sharedTransport returns a keep-alive transport reused by every call with
+// the same tunables, so repeated requests to a host share connections.
+// Under js/wasm requests go through the Fetch API, and pooling and TLS
+// settings are left to the host runtime
+func sharedTransport(config *Config) *http.Transport {
+	key := transportKey{
+		maxIdleConns:        config.MaxIdleConns,
+		maxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		idleConnTimeout:     config.IdleConnTimeout,
+	}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if transport, ok := transports[key]; ok {
+		return transport
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        key.maxIdleConns,
+		MaxIdleConnsPerHost: key.maxIdleConnsPerHost,
+		IdleConnTimeout:     key.idleConnTimeout,
+	}
+	transports[key] = transport
+	return transport
+}
+
+// unsupportedOptions are transport options the Fetch API gives no control
+// over. TLS settings in particular are the runtime's policy, so there is
+// no way to skip verification or raise the minimum version
+var unsupportedOptions = []string{"insecureSkipVerify", "minTlsVersion", "maxIdleConns", "maxIdleConnsPerHost"}
+
+// parseOptions overlays caller-supplied options onto the configuration
+func parseOptions(config *Config, options js.Value) error {
+	var raw string
+	switch options.Type() {
+	case js.TypeUndefined, js.TypeNull:
+		return nil
+	case js.TypeString:
+		raw = options.String()
+	case js.TypeObject:
+		raw = js.Global().Get("JSON").Call("stringify", options).String()
+	default:
+		return fmt.Errorf("invalid options: expected object, got %s", options.Type())
+	}
+
+	// The strength preset goes first so explicit options override it
+	var preset struct {
+		Strength string `json:"strength"`
+	}
+	if err := json.Unmarshal([]byte(raw), &preset); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	if err := applyStrength(config, preset.Strength); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(raw), config); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+
+	// Transport options would be silently ignored under js/wasm, where
+	// fetch applies its own TLS and connection policy
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &keys); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	for _, key := range unsupportedOptions {
+		if _, ok := keys[key]; ok {
+			return fmt.Errorf("invalid options: %s is not supported under js/wasm, where requests go through the runtime's fetch", key)
+		}
+	}
+	if config.MaxHeadingDepth < 1 || config.MaxHeadingDepth > 6 {
+		return fmt.Errorf("invalid options: maxHeadingDepth must be between 1 and 6")
+	}
+	if config.PreviewLength < 1 {
+		return fmt.Errorf("invalid options: previewLength must be at least 1")
+	}
+
+	// Durations are given in milliseconds
+	var timeouts struct {
+		Timeout           *int64 `json:"timeout"`
+		ConnectTimeout    *int64 `json:"connectTimeout"`
+		ProcessingTimeout *int64 `json:"processingTimeout"`
+	}
+	if err := json.Unmarshal([]byte(raw), &timeouts); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	if timeouts.Timeout != nil {
+		config.RequestTimeout = time.Duration(*timeouts.Timeout) * time.Millisecond
+	}
+	if timeouts.ConnectTimeout != nil {
+		config.ConnectTimeout = time.Duration(*timeouts.ConnectTimeout) * time.Millisecond
+	}
+	if timeouts.ProcessingTimeout != nil {
+		config.ProcessingTimeout = time.Duration(*timeouts.ProcessingTimeout) * time.Millisecond
+	}
+	return nil
+}
+
+// applyStrength sets the cleaning flags bundled by a strength preset
+func applyStrength(config *Config, strength string) error {
+	switch strength {
+	case "", "balanced":
+	case "minimal":
+		config.RemoveChrome = false
+		config.FuzzyRemoval = false
+		config.RemoveLinkClusters = false
+		config.KeepForms = true
+		config.MinImageSize = 0
+		config.SVGMode = "keep"
+	case "aggressive":
+		config.RemoveChrome = true
+		config.FuzzyRemoval = true
+		config.RemoveLinkClusters = true
+		config.MinImageSize = 100
+		config.SVGMode = "strip"
+	default:
+		return fmt.Errorf("invalid options: unknown strength %q", strength)
+	}
+	return nil
+}
+
+// aggressiveSelectors and aggressivePhrases extend cleaning at the
+// "aggressive" strength
+var (
+	aggressiveSelectors = []string{
+		".related", ".related-posts", ".recommended", ".newsletter", ".subscribe",
+		".share", ".sharing", ".social", ".promo", ".author-bio", ".tags",
+		"[class*='related-']", "[class*='newsletter']",
+	}
+	aggressivePhrases = []string{
+		"subscribe", "newsletter", "sign up for", "read more", "related articles",
+		"related stories", "related posts", "recommended for you", "share this",
+		"follow us", "you may also like", "trending now",
+	}
+)
+
+// fetchedPage is a fetched and parsed HTML document
+type fetchedPage struct {
+	doc    *goquery.Document
+	url    *url.URL // final URL after redirects
+	size   int      // body size in bytes
+	source string   // body as parsed, after UTF-8 cleanup
+
+	received time.Time // when the body was read, before parsing
+}
+
+// fetchPage fetches and parses an HTML document, enforcing the redirect,
+// size and content type limits. A body cut short by the connection is
+// fetched once more before failing with incomplete_response
+func fetchPage(targetURL string, config *Config) (*fetchedPage, error) {
+	page, err := fetchPageAttempt(targetURL, config)
+	var readerErr *ReaderError
+	if errors.As(err, &readerErr) && readerErr.Code == "incomplete_response" {
+		page, err = fetchPageAttempt(targetURL, config)
+	}
+	return page, err
+}
 
+// fetchPageAttempt makes a single attempt at fetchPage
+func fetchPageAttempt(targetURL string, config *Config) (*fetchedPage, error) {
 	// Create HTTP client with timeout
 	client := &http.Client{
-		Timeout: config.RequestTimeout,
+		Transport: sharedTransport(config),
+		Timeout:   config.RequestTimeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) >= 10 {
 				return fmt.Errorf("too many redirects")
 			}
 			if len(via) > 0 {
-				req.Header.Set("User-Agent", via[0].Header.Get("User-Agent"))
-				req.Header.Set("Accept", via[0].Header.Get("Accept"))
-				req.Header.Set("Accept-Language", via[0].Header.Get("Accept-Language"))
+				forwardRedirectHeaders(req, via[0], config.RedirectHeaders)
 			}
 			return nil
 		},
 	}
 
+	if config.PrecheckHead {
+		if err := precheckHead(client, targetURL, config); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create request with headers
-	req, err := http.NewRequest("GET", targetURL, nil)
+	req, err := newRequest("GET", targetURL, config)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, err
 	}
 
-	req.Header.Set("User-Agent", config.UserAgent)
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-
 	// Fetch the webpage
-	resp, err := client.Do(req)
+	resp, err := sendRequest(client, req, config.ConnectTimeout)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch URL: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP error: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		// Challenge pages are usually served as 403/503, so inspect the start
+		// of the body before reporting a plain HTTP error
+		preview, _ := io.ReadAll(io.LimitReader(resp.Body, 256*1024))
+		if reason := detectBotChallenge(resp, preview); reason != "" {
+			return nil, &ReaderError{Code: "bot_challenge", Message: "bot challenge detected: " + reason}
+		}
+		return nil, fmt.Errorf("HTTP error: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	// Only markup can be parsed; a missing Content-Type is given the benefit of the doubt
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !isMarkupType(contentType) {
+		return nil, &ReaderError{Code: "unsupported_content_type", Message: "unsupported content type: " + contentType}
 	}
 
 	// Check content length
 	if resp.ContentLength > config.MaxContentSize {
-		return "", fmt.Errorf("content too large: %d bytes (max: %d)", resp.ContentLength, config.MaxContentSize)
+		return nil, fmt.Errorf("content too large: %d bytes (max: %d)", resp.ContentLength, config.MaxContentSize)
 	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
+	// Read response body, bounded so chunked responses without a
+	// Content-Length cannot exceed the limit either
+	body, err := io.ReadAll(io.LimitReader(resp.Body, config.MaxContentSize+1))
+	var timeout interface{ Timeout() bool }
+	if err != nil && errors.As(err, &timeout) && timeout.Timeout() {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if int64(len(body)) > config.MaxContentSize {
+		return nil, fmt.Errorf("content too large: more than %d bytes", config.MaxContentSize)
+	}
+	if err := incompleteBody(resp, len(body), err); err != nil {
+		return nil, err
 	}
 
+	if reason := detectBotChallenge(resp, body); reason != "" {
+		return nil, &ReaderError{Code: "bot_challenge", Message: "bot challenge detected: " + reason}
+	}
+
+	received := time.Now()
+
 	// Handle character encoding
 	htmlContent := string(body)
 	if !utf8.Valid(body) {
@@ -94,301 +819,6018 @@ func processURL(targetURL string) (string, error) {
 	// Parse HTML
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
 	if err != nil {
-		return "", fmt.Errorf("failed to parse HTML: %v", err)
+		return nil, fmt.Errorf("failed to parse HTML: %v", err)
 	}
 
-	// Extract metadata
-	title := extractTitle(doc)
-	author := extractAuthor(doc)
-	publishDate := extractPublishDate(doc)
-	description := extractDescription(doc)
-
-	// Clean document
-	cleanDocument(doc)
-
-	// Extract content
-	contentHTML := extractMainContent(doc)
-
-	// Generate readable page
-	result := generateReadablePage(title, contentHTML, targetURL, author, publishDate, description)
-	return result, nil
+	return &fetchedPage{doc: doc, url: resp.Request.URL, size: len(body), source: htmlContent, received: received}, nil
 }
 
-// extractTitle extracts the page title
-func extractTitle(doc *goquery.Document) string {
-	titleSources := []string{
-		"meta[property='og:title']",
-		"meta[name='twitter:title']",
-		"h1",
-		"title",
+// incompleteBody reports a body cut short by a read error, such as a
+// connection reset, or shorter than its declared Content-Length. The length
+// is only compared for unencoded bodies, since Content-Length counts the
+// compressed bytes
+func incompleteBody(resp *http.Response, read int, readErr error) error {
+	expected := resp.ContentLength
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		expected = -1
+	}
+	if readErr == nil && (expected < 0 || int64(read) >= expected) {
+		return nil
 	}
 
-	for _, selector := range titleSources {
-		if title := doc.Find(selector).First().AttrOr("content", ""); title != "" {
-			return strings.TrimSpace(title)
-		}
-		if title := doc.Find(selector).First().Text(); title != "" {
-			return strings.TrimSpace(title)
-		}
+	details := map[string]interface{}{"bytesRead": read}
+	message := fmt.Sprintf("incomplete response: read %d bytes", read)
+	if expected >= 0 {
+		details["bytesExpected"] = expected
+		message += fmt.Sprintf(" of %d", expected)
 	}
-	return "Untitled"
+	if readErr != nil {
+		message += fmt.Sprintf(": %v", readErr)
+	}
+	return &ReaderError{Code: "incomplete_response", Message: message, Details: details}
 }
 
-// extractAuthor extracts author information
-func extractAuthor(doc *goquery.Document) string {
-	authorSelectors := []string{
-		"meta[name='author']",
-		"meta[property='article:author']",
-		".author",
-		".byline",
-		"[rel='author']",
+// safeRedirectHeaders carry no credentials, so they may follow redirects
+// to other origins
+var safeRedirectHeaders = []string{"User-Agent", "Accept", "Accept-Language", "DNT", "Upgrade-Insecure-Requests"}
+
+// jsFetchRedirect is the net/http header that sets the Fetch API redirect
+// mode under js/wasm. Fetch follows redirects itself by default, bypassing
+// CheckRedirect, so requests ask for "manual" and the client follows them
+const jsFetchRedirect = "js.fetch:redirect"
+
+// forwardRedirectHeaders sets the headers of a redirected request from the
+// original one. Policy "all" forwards everything, "safe" only the safe
+// headers, and "same-origin" (the default) everything while the redirect
+// stays on the original scheme and host, then only the safe headers
+func forwardRedirectHeaders(req, first *http.Request, policy string) {
+	defer func() { req.Header.Set(jsFetchRedirect, "manual") }()
+
+	sameOrigin := req.URL.Scheme == first.URL.Scheme && req.URL.Host == first.URL.Host
+	if policy == "all" || (policy != "safe" && sameOrigin) {
+		req.Header = first.Header.Clone()
+		return
 	}
 
-	for _, selector := range authorSelectors {
-		if author := doc.Find(selector).First().AttrOr("content", ""); author != "" {
-			return strings.TrimSpace(author)
-		}
-		if author := doc.Find(selector).First().Text(); author != "" {
-			return strings.TrimSpace(author)
+	req.Header = http.Header{}
+	for _, name := range safeRedirectHeaders {
+		if value := first.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
 		}
 	}
-	return ""
 }
 
-// extractPublishDate extracts publication date
-func extractPublishDate(doc *goquery.Document) string {
-	dateSelectors := []string{
-		"meta[property='article:published_time']",
-		"meta[name='date']",
-		"time[datetime]",
-		".date",
-		".published",
+// doRequest sends req, letting Fetch follow the redirect itself when the
+// runtime hides a manual redirect behind an opaque status 0 response, as
+// browsers do; Cloudflare Workers expose the redirect to the client
+func doRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != 0 {
+		return resp, err
 	}
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	retry.Header.Set(jsFetchRedirect, "follow")
+	return client.Do(retry)
+}
 
-	for _, selector := range dateSelectors {
-		if date := doc.Find(selector).First().AttrOr("content", ""); date != "" {
-			return strings.TrimSpace(date)
-		}
-		if date := doc.Find(selector).First().AttrOr("datetime", ""); date != "" {
-			return strings.TrimSpace(date)
-		}
-		if date := doc.Find(selector).First().Text(); date != "" {
-			return strings.TrimSpace(date)
+// errConnectTimeout cancels a request whose response headers are late
+var errConnectTimeout = errors.New("connect timeout")
+
+// sendRequest sends req and cancels it if the response headers have not
+// arrived within connectTimeout. The Fetch API used under js/wasm has no
+// dial or TLS hooks, so the deadline is enforced through the request
+// context; the body read remains bounded by the client's overall Timeout
+func sendRequest(client *http.Client, req *http.Request, connectTimeout time.Duration) (*http.Response, error) {
+	if connectTimeout <= 0 {
+		resp, err := doRequest(client, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch URL: %v", err)
 		}
+		return resp, nil
 	}
-	return ""
-}
 
-// extractDescription extracts page description
-func extractDescription(doc *goquery.Document) string {
-	descSelectors := []string{
-		"meta[property='og:description']",
-		"meta[name='description']",
-		"meta[name='twitter:description']",
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(connectTimeout, func() { cancel(errConnectTimeout) })
+	resp, err := doRequest(client, req.WithContext(ctx))
+	if !timer.Stop() && err == nil {
+		// The deadline fired as the headers arrived, so the body is unusable
+		resp.Body.Close()
+		err = errConnectTimeout
 	}
-
-	for _, selector := range descSelectors {
-		if desc := doc.Find(selector).First().AttrOr("content", ""); desc != "" {
-			return strings.TrimSpace(desc)
+	if err != nil {
+		cancel(nil)
+		if errors.Is(context.Cause(ctx), errConnectTimeout) {
+			return nil, &ReaderError{Code: "connect_timeout", Message: fmt.Sprintf("no response within %v", connectTimeout)}
 		}
+		return nil, fmt.Errorf("failed to fetch URL: %v", err)
 	}
-	return ""
-}
 
-// cleanDocument removes unwanted elements
-func cleanDocument(doc *goquery.Document) {
-	unwantedSelectors := []string{
-		"script", "style", "noscript", "iframe", "embed", "object",
-		"nav", "header", "footer", "aside",
-		".advertisement", ".ads", ".ad", ".social-share", ".social-sharing",
-		".comments", ".comment", ".sidebar", ".navigation", ".menu",
-		".popup", ".modal", ".overlay", ".banner", ".cookie-notice",
-		"[aria-hidden='true']", ".screen-reader-text", ".visually-hidden",
-	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
 
-	for _, selector := range unwantedSelectors {
-		doc.Find(selector).Remove()
-	}
+// cancelOnClose releases a request context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
 
-	// Remove suspicious content
-	doc.Find("*").Each(func(i int, s *goquery.Selection) {
-		text := strings.ToLower(s.Text())
-		if strings.Contains(text, "advertisement") ||
-			strings.Contains(text, "sponsored") ||
-			strings.Contains(text, "cookie") && strings.Contains(text, "accept") {
-			s.Remove()
-		}
-	})
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel(nil)
+	return err
 }
 
-// extractMainContent finds main content
-func extractMainContent(doc *goquery.Document) string {
-	contentSelectors := []string{
-		"article", "main", "[role='main']",
-		".post-content", ".entry-content", ".article-content",
-		".content", "#content", "#main",
-		".post", ".entry", ".article",
+// newRequest creates a request carrying the reader's browser-like headers
+func newRequest(method, targetURL string, config *Config) (*http.Request, error) {
+	req, err := http.NewRequest(method, targetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	var contentSelection *goquery.Selection
-	var maxLength int
-
-	for _, selector := range contentSelectors {
-		selection := doc.Find(selector).First()
-		if selection.Length() > 0 {
-			text := selection.Text()
-			if len(text) > maxLength {
-				maxLength = len(text)
-				contentSelection = selection
-			}
+	req.Header.Set("User-Agent", config.UserAgent)
+	if config.Accept != "" {
+		req.Header.Set("Accept", config.Accept)
+	}
+	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	req.Header.Set("DNT", "1")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+	req.Header.Set(jsFetchRedirect, "manual")
+	return req, nil
+}
+
+// precheckHead issues a HEAD request and rejects responses that declare a
+// non-markup type or a size over the limit before the body is downloaded.
+// Servers that fail or refuse HEAD are given the benefit of the doubt
+func precheckHead(client *http.Client, targetURL string, config *Config) error {
+	req, err := newRequest("HEAD", targetURL, config)
+	if err != nil {
+		return err
+	}
+	resp, err := sendRequest(client, req, config.ConnectTimeout)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !isMarkupType(contentType) {
+		return &ReaderError{Code: "unsupported_content_type", Message: "unsupported content type: " + contentType}
+	}
+	if resp.ContentLength > config.MaxContentSize {
+		return fmt.Errorf("content too large: %d bytes (max: %d)", resp.ContentLength, config.MaxContentSize)
+	}
+	return nil
+}
+
+// processURL fetches and processes a URL, returning the rendered output keyed by format
+func processURL(targetURL string, config *Config) (map[string]interface{}, error) {
+	page, err := fetchPage(targetURL, config)
+	if err != nil {
+		return nil, err
+	}
+	doc := page.doc
+
+	// The processing budget starts once the body is read, so it covers
+	// parsing as well as cleaning
+	ctx := context.Background()
+	if config.ProcessingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, page.received.Add(config.ProcessingTimeout))
+		defer cancel()
+	}
+	if err := checkDeadline(ctx, "parsing"); err != nil {
+		return nil, err
+	}
+
+	// Extract metadata
+	pageURL := page.url
+	jsonLD := extractJSONLD(doc)
+	article := &Article{
+		URL:          targetURL,
+		Title:        extractTitle(doc, messagesFor(config.UILanguage).Untitled),
+		Authors:      extractAuthors(doc),
+		PublishDate:  extractPublishDate(doc),
+		ModifiedDate: extractModifiedDate(doc, jsonLD),
+		Description:  extractDescription(doc),
+		Language:     extractLanguage(doc),
+		Section:      extractSection(doc, jsonLD),
+		Breadcrumbs:  extractBreadcrumbs(doc, jsonLD, pageURL),
+		ArticleID:    articleID(extractCanonicalURL(doc, pageURL)),
+		Domain:       extractDomain(doc, pageURL),
+		Logo:         extractLogo(doc, jsonLD, pageURL),
+		ThemeColor:   extractThemeColor(doc),
+		Engagement:   extractEngagement(doc, jsonLD),
+		Keywords:     extractKeywords(doc, jsonLD),
+		Location:     extractLocation(doc, jsonLD),
+		LeadImage:    extractLeadImage(doc, jsonLD, pageURL),
+	}
+	article.AuthorURL, article.AuthorImage = extractAuthorProfile(doc, jsonLD, pageURL)
+	if config.StyleMode == "original" {
+		article.Stylesheets = extractStylesheets(doc, pageURL)
+	}
+	if config.SameOriginImages && article.Logo != "" {
+		if logo, err := url.Parse(article.Logo); err == nil && !sameSite(logo, pageURL) {
+			article.Logo = ""
+		}
+	}
+	if config.SameOriginImages && article.AuthorImage != "" {
+		if avatar, err := url.Parse(article.AuthorImage); err == nil && !sameSite(avatar, pageURL) {
+			article.AuthorImage = ""
+		}
+	}
+
+	version := "original"
+	if config.PreferAlternate {
+		if alternate, kind := fetchAlternate(doc, pageURL, config); alternate != nil {
+			page, doc, pageURL, version = alternate, alternate.doc, alternate.url, kind
+		}
+	}
+
+	// Frames are fetched before cleaning, which removes iframes
+	if config.InlineFrames {
+		inlineFrames(ctx, doc, pageURL, config)
+	}
+
+	// Threads and recipes come from markup that cleaning removes, so the
+	// specialised modes extract first and fall back to the article
+	var content *goquery.Selection
+	switch config.Mode {
+	case "forum":
+		content = extractThread(doc)
+	case "recipe":
+		content = extractRecipe(jsonLD, messagesFor(config.UILanguage))
+	case "list":
+		content, article.Entries = extractListing(doc, pageURL, config.PreviewLength)
+	}
+	if content == nil {
+		content = extractSlides(doc)
+	}
+	if err := checkDeadline(ctx, "metadata"); err != nil {
+		return nil, err
+	}
+
+	// Clean document
+	if err := cleanDocument(ctx, doc, config); err != nil {
+		return nil, err
+	}
+
+	// Extract content
+	if content == nil {
+		content = extractMainContent(doc, config)
+	}
+	if config.AMPFallback && version == "original" && len(strings.TrimSpace(content.Text())) < minMainContentLength {
+		if amp, kind := fetchAlternate(doc, pageURL, config, "amp"); amp != nil {
+			page, doc, pageURL, version = amp, amp.doc, amp.url, kind
+			if err := cleanDocument(ctx, doc, config); err != nil {
+				return nil, err
+			}
+			content = extractMainContent(doc, config)
+		}
+	}
+	if err := checkDeadline(ctx, "extraction"); err != nil {
+		return nil, err
+	}
+	if config.StripDuplicateTitle {
+		stripDuplicateTitle(content, article.Title)
+	}
+	if config.Reviews {
+		if reviews := renderReviews(jsonLD, messagesFor(config.UILanguage)); reviews != "" {
+			content.First().AppendHtml(reviews)
+		}
+	}
+	processContent(content, pageURL, config)
+	if err := checkDeadline(ctx, "processing"); err != nil {
+		return nil, err
+	}
+	if config.LabelSponsored {
+		article.Sponsored = content.Find(".reader-sponsored").Length() > 0
+	}
+
+	if config.CollectImages {
+		article.Images = collectImages(content, pageURL)
+	}
+	article.LeadImage = completeLeadImage(article.LeadImage, content, pageURL, config)
+
+	var toc []TOCEntry
+	if config.TableOfContents {
+		toc = buildTableOfContents(content)
+	}
+
+	// Segment last, so the ids match the content as returned
+	var segments []Segment
+	if config.Segments {
+		segments = segmentSentences(content)
+	}
+
+	contentHTML, err := content.Html()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize content: %v", err)
+	}
+	if config.Canonical {
+		contentHTML = canonicalHTML(contentHTML)
+	}
+	article.Content = contentHTML
+	article.Direction = detectDirection(doc, content, article.Language)
+	plainText := extractPlainText(article.Content)
+	article.Preview = generatePreview(article.Description, plainText, config.PreviewLength)
+	article.ContentHash = contentHash(plainText)
+	if len(article.Keywords) == 0 && config.DeriveKeywords {
+		article.Keywords = deriveKeywords(plainText)
+	}
+	if config.Sentiment && (article.Language == "" || strings.HasPrefix(strings.ToLower(article.Language), "en")) {
+		prose := content.Clone()
+		prose.Find(codeSelector).Remove()
+		article.Sentiment = scoreSentiment(prose.Text())
+	}
+	if published, ok := parseDate(article.PublishDate); ok {
+		article.Freshness = relativeTime(published, time.Now(), messagesFor(config.UILanguage))
+	}
+
+	// Render the requested output format
+	result, err := renderArticle(article, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.IncludePlainText {
+		result["plainText"] = plainText
+	}
+	if config.Segments {
+		result["segments"] = segments
+	}
+	if config.TableOfContents {
+		result["toc"] = toc
+	}
+	if config.IncludeRawHTML {
+		result["rawHtml"] = page.source
+	}
+	if config.PreferAlternate || version != "original" {
+		result["version"] = version
+	}
+
+	// Report the body size so callers can judge memory pressure
+	result["contentSize"] = page.size
+	if config.LargeContentThreshold > 0 && int64(page.size) > config.LargeContentThreshold {
+		result["largeContent"] = true
+	}
+	return result, nil
+}
+
+// alternateLinks locate print-friendly and AMP versions of a page, in
+// order of preference
+var alternateLinks = []struct{ selector, kind string }{
+	{"link[rel~='alternate'][media*='print'][href]", "print"},
+	{"link[rel='amphtml'][href]", "amp"},
+}
+
+// deadlineCheckInterval is how many elements the long per-element
+// cleaning passes visit between deadline checks
+const deadlineCheckInterval = 256
+
+// checkDeadline fails with processing_timeout once ctx's deadline has
+// passed, naming the stage that overran. The passes never yield under
+// js/wasm, so the deadline is compared directly rather than waiting for
+// the context's timer to fire
+func checkDeadline(ctx context.Context, stage string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Now().Before(deadline) {
+		return nil
+	}
+	return &ReaderError{
+		Code:    "processing_timeout",
+		Message: "processing timed out during " + stage,
+		Details: map[string]interface{}{"stage": stage},
+	}
+}
+
+// fetchAlternate fetches the page's preferred alternate version on the same
+// site, limited to the given kinds when any are given, returning nil when
+// there is none or it fails to load
+func fetchAlternate(doc *goquery.Document, pageURL *url.URL, config *Config, kinds ...string) (*fetchedPage, string) {
+	for _, candidate := range alternateLinks {
+		if len(kinds) > 0 && !slices.Contains(kinds, candidate.kind) {
+			continue
+		}
+		href := resolveURL(pageURL, doc.Find(candidate.selector).First().AttrOr("href", ""))
+		link, err := url.Parse(href)
+		if href == "" || err != nil || !sameSite(link, pageURL) || link.String() == pageURL.String() {
+			continue
+		}
+		if alternate, err := fetchPage(href, config); err == nil {
+			return alternate, candidate.kind
+		}
+	}
+	return nil, ""
+}
+
+// maxInlineFrames caps the number of iframes fetched per page
+const maxInlineFrames = 3
+
+// inlineFrames replaces same-origin iframes with the main content of the
+// documents they load. Frames that fail to load are left for cleaning to
+// remove, and nested frames are not followed
+func inlineFrames(ctx context.Context, doc *goquery.Document, pageURL *url.URL, config *Config) {
+	fetched := 0
+	doc.Find("iframe[src]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		frameURL, err := pageURL.Parse(strings.TrimSpace(s.AttrOr("src", "")))
+		if err != nil || frameURL.Scheme != pageURL.Scheme || frameURL.Host != pageURL.Host {
+			return true
+		}
+
+		fetched++
+		frame, err := fetchPage(frameURL.String(), config)
+		// Redirects may leave the origin, so check where the frame ended up
+		if err != nil || frame.url.Scheme != pageURL.Scheme || frame.url.Host != pageURL.Host {
+			return fetched < maxInlineFrames
+		}
+
+		frame.doc.Find("iframe").Remove()
+		if err := cleanDocument(ctx, frame.doc, config); err != nil {
+			return false
+		}
+		content := extractMainContent(frame.doc, config)
+		absolutizeURLs(content, frame.url)
+		if contentHTML, err := content.Html(); err == nil && strings.TrimSpace(content.Text()) != "" {
+			s.ReplaceWithHtml(`<div class="reader-frame">` + contentHTML + `</div>`)
+		}
+		return fetched < maxInlineFrames
+	})
+}
+
+// absolutizeURLs resolves link and image references against base, so
+// content moved out of its document keeps working
+func absolutizeURLs(content *goquery.Selection, base *url.URL) {
+	for _, attr := range []string{"href", "src", "poster"} {
+		content.Find("[" + attr + "]").Each(func(i int, s *goquery.Selection) {
+			ref := s.AttrOr(attr, "")
+			if strings.HasPrefix(ref, "#") {
+				return
+			}
+			if resolved := resolveURL(base, ref); resolved != "" {
+				s.SetAttr(attr, resolved)
+			}
+		})
+	}
+	content.Find("[srcset]").Each(func(i int, s *goquery.Selection) {
+		candidates := strings.Split(s.AttrOr("srcset", ""), ",")
+		for j, candidate := range candidates {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+			if resolved := resolveURL(base, fields[0]); resolved != "" {
+				fields[0] = resolved
+			}
+			candidates[j] = strings.Join(fields, " ")
+		}
+		s.SetAttr("srcset", strings.Join(candidates, ", "))
+	})
+}
+
+// isMarkupType reports whether a Content-Type header names HTML or XML
+func isMarkupType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "application/xml", "text/xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// renderArticle renders an extracted article in each configured output
+// format, merging their result keys
+func renderArticle(article *Article, config *Config) (map[string]interface{}, error) {
+	formats := config.Formats
+	if len(formats) == 0 {
+		formats = []string{config.Format}
+	}
+	result := make(map[string]interface{})
+	for _, format := range formats {
+		rendered, err := renderFormat(article, config, format)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range rendered {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// renderFormat renders an extracted article in a single output format
+func renderFormat(article *Article, config *Config, format string) (map[string]interface{}, error) {
+	switch format {
+	case "", "html":
+		page, truncated, err := generateReadablePage(article, config)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]interface{}{"html": page}
+		if truncated {
+			result["truncated"] = true
+		}
+		if config.StyleMode == "external" {
+			css, err := generateStylesheet(config)
+			if err != nil {
+				return nil, err
+			}
+			result["css"] = css
+		}
+		return result, nil
+	case "json":
+		return map[string]interface{}{"json": article}, nil
+	case "email":
+		return map[string]interface{}{"email": generateEmailText(article, config.WrapWidth, messagesFor(config.UILanguage))}, nil
+	case "html5":
+		document, err := generateSemanticDocument(article, messagesFor(config.UILanguage))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"html5": document}, nil
+	case "rss":
+		item, err := generateRSSItem(article)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"rss": item}, nil
+	case "atom":
+		entry, err := generateAtomEntry(article)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"atom": entry}, nil
+	case "jsonfeed":
+		return map[string]interface{}{"jsonfeed": generateJSONFeedItem(article)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// botChallengeTitles are page titles used by bot-protection interstitials
+var botChallengeTitles = []string{
+	"just a moment...",
+	"attention required! | cloudflare",
+	"please wait... | cloudflare",
+	"checking your browser",
+	"ddos-guard",
+	"pardon our interruption",
+}
+
+// botChallengeMarkers are body fragments of challenge pages. Some, such
+// as the challenge-platform script, are also injected into ordinary pages,
+// so they only count on error responses
+var botChallengeMarkers = []string{
+	"cf-browser-verification",
+	"cf_chl_opt",
+	"/cdn-cgi/challenge-platform/",
+	"checking your browser before accessing",
+	"enable javascript and cookies to continue",
+	"captcha-delivery.com",
+	"_incapsula_resource",
+	"px-captcha",
+}
+
+// detectBotChallenge reports why a response looks like a bot-challenge
+// interstitial rather than the requested page, or "" if it does not.
+// Successful responses are judged by headers, cookies and title only
+func detectBotChallenge(resp *http.Response, body []byte) string {
+	if strings.EqualFold(resp.Header.Get("Cf-Mitigated"), "challenge") {
+		return "cf-mitigated header"
+	}
+	for _, cookie := range resp.Cookies() {
+		if strings.HasPrefix(cookie.Name, "cf_chl") {
+			return "challenge cookie " + cookie.Name
+		}
+	}
+
+	lower := strings.ToLower(string(body))
+	if start := strings.Index(lower, "<title>"); start >= 0 {
+		if end := strings.Index(lower[start:], "</title>"); end >= 0 {
+			title := strings.TrimSpace(lower[start+len("<title>") : start+end])
+			for _, marker := range botChallengeTitles {
+				if strings.HasPrefix(title, marker) {
+					return fmt.Sprintf("challenge page title %q", title)
+				}
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return ""
+	}
+	for _, marker := range botChallengeMarkers {
+		if strings.Contains(lower, marker) {
+			return fmt.Sprintf("challenge marker %q", marker)
+		}
+	}
+	return ""
+}
+
+// extractTitle extracts the page title
+func extractTitle(doc *goquery.Document, untitled string) string {
+	titleSources := []string{
+		"meta[property='og:title']",
+		"meta[name='twitter:title']",
+		"h1",
+		"title",
+	}
+
+	for _, selector := range titleSources {
+		if title := doc.Find(selector).First().AttrOr("content", ""); title != "" {
+			return strings.TrimSpace(title)
+		}
+		if title := doc.Find(selector).First().Text(); title != "" {
+			return strings.TrimSpace(title)
+		}
+	}
+	return untitled
+}
+
+// extractAuthors extracts the authors credited by the first byline found
+func extractAuthors(doc *goquery.Document) []string {
+	authorSelectors := []string{
+		"meta[name='author']",
+		"meta[property='article:author']",
+		".author",
+		".byline",
+		"[rel='author']",
+	}
+
+	// Only the first match is read, as later ones are usually comment or
+	// sidebar authors rather than co-authors
+	for _, selector := range authorSelectors {
+		selection := doc.Find(selector).First()
+		value := selection.AttrOr("content", "")
+		if value == "" {
+			value = selection.Text()
+		}
+
+		var authors []string
+		seen := make(map[string]bool)
+		for _, name := range splitByline(value) {
+			if key := strings.ToLower(name); !seen[key] {
+				seen[key] = true
+				authors = append(authors, name)
+			}
+		}
+		if len(authors) > 0 {
+			return authors
+		}
+	}
+	return nil
+}
+
+// extractAuthorProfile returns the first author's profile URL and avatar
+// from JSON-LD author.url and author.image, falling back to a rel=author
+// link or an article:author URL for the profile
+func extractAuthorProfile(doc *goquery.Document, jsonLD []map[string]interface{}, pageURL *url.URL) (string, string) {
+	var profile, avatar string
+	for _, object := range jsonLD {
+		// Authors given as plain names have neither
+		if profile == "" {
+			profile = resolveURL(pageURL, jsonLDString(jsonLDValue(object, "author", "url")))
+		}
+		if avatar == "" {
+			avatar = resolveURL(pageURL, jsonLDString(jsonLDValue(object, "author", "image"), "url", "contentUrl"))
+		}
+	}
+	if profile == "" {
+		profile = resolveURL(pageURL, doc.Find("a[rel~='author'][href], link[rel~='author'][href]").First().AttrOr("href", ""))
+	}
+	if profile == "" {
+		if value := strings.TrimSpace(doc.Find("meta[property='article:author']").First().AttrOr("content", "")); strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			profile = value
+		}
+	}
+	// The profile is linked from the byline, so only web links are kept
+	if !strings.HasPrefix(profile, "http://") && !strings.HasPrefix(profile, "https://") {
+		profile = ""
+	}
+	return profile, avatar
+}
+
+// splitByline splits a byline such as "By A, B and C" into names
+func splitByline(byline string) []string {
+	byline = strings.Join(strings.Fields(byline), " ")
+	if lower := strings.ToLower(byline); strings.HasPrefix(lower, "by ") {
+		byline = byline[3:]
+	}
+
+	var names []string
+	for _, part := range strings.Split(byline, ",") {
+		part = strings.TrimSpace(part)
+		part = strings.TrimPrefix(part, "and ")
+		for _, name := range strings.Split(strings.ReplaceAll(part, " & ", " and "), " and ") {
+			name = strings.TrimSpace(name)
+			// Profile URLs are not names
+			if name == "" || strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// joinNatural joins names as "A", "A and B" or "A, B, and C"
+func joinNatural(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	case 2:
+		return names[0] + " and " + names[1]
+	default:
+		return strings.Join(names[:len(names)-1], ", ") + ", and " + names[len(names)-1]
+	}
+}
+
+// extractPublishDate extracts publication date
+func extractPublishDate(doc *goquery.Document) string {
+	dateSelectors := []string{
+		"meta[property='article:published_time']",
+		"meta[name='date']",
+		"time[datetime]",
+		".date",
+		".published",
+	}
+
+	for _, selector := range dateSelectors {
+		if date := doc.Find(selector).First().AttrOr("content", ""); date != "" {
+			return strings.TrimSpace(date)
+		}
+		if date := doc.Find(selector).First().AttrOr("datetime", ""); date != "" {
+			return strings.TrimSpace(date)
+		}
+		if date := doc.Find(selector).First().Text(); date != "" {
+			return strings.TrimSpace(date)
+		}
+	}
+	return ""
+}
+
+// extractModifiedDate returns when the article was last updated, from
+// meta tags or JSON-LD dateModified
+func extractModifiedDate(doc *goquery.Document, jsonLD []map[string]interface{}) string {
+	for _, selector := range []string{
+		"meta[property='article:modified_time']",
+		"meta[property='og:updated_time']",
+		"meta[name='last-modified']",
+		"meta[http-equiv='last-modified' i]",
+	} {
+		if date := strings.TrimSpace(doc.Find(selector).First().AttrOr("content", "")); date != "" {
+			return date
+		}
+	}
+	for _, object := range jsonLD {
+		if date := jsonLDString(object["dateModified"]); date != "" {
+			return date
+		}
+	}
+	return ""
+}
+
+// extractKeywords collects declared keywords and tags from meta tags and
+// JSON-LD, deduplicated case-insensitively in order of appearance
+func extractKeywords(doc *goquery.Document, jsonLD []map[string]interface{}) []string {
+	var keywords []string
+	seen := map[string]bool{}
+	add := func(list string) {
+		for _, keyword := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ';' }) {
+			keyword = strings.Join(strings.Fields(keyword), " ")
+			if key := strings.ToLower(keyword); key != "" && !seen[key] {
+				seen[key] = true
+				keywords = append(keywords, keyword)
+			}
+		}
+	}
+
+	doc.Find("meta[name='keywords' i], meta[name='news_keywords' i]").Each(func(i int, s *goquery.Selection) {
+		add(s.AttrOr("content", ""))
+	})
+	doc.Find("meta[property='article:tag']").Each(func(i int, s *goquery.Selection) {
+		// A tag is a single keyword, so commas are kept
+		if tag := strings.Join(strings.Fields(s.AttrOr("content", "")), " "); tag != "" && !seen[strings.ToLower(tag)] {
+			seen[strings.ToLower(tag)] = true
+			keywords = append(keywords, tag)
+		}
+	})
+	for _, object := range jsonLD {
+		switch value := object["keywords"].(type) {
+		case string:
+			add(value)
+		case []interface{}:
+			for _, item := range value {
+				add(jsonLDString(item, "name"))
+			}
+		}
+	}
+	return keywords
+}
+
+// keywordStopwords are common English words never used as derived keywords
+var keywordStopwords = map[string]bool{
+	"about": true, "after": true, "again": true, "also": true, "been": true, "before": true,
+	"being": true, "between": true, "both": true, "could": true, "does": true, "doing": true,
+	"down": true, "during": true, "each": true, "even": true, "every": true, "from": true,
+	"have": true, "having": true, "here": true, "into": true, "just": true, "like": true,
+	"made": true, "make": true, "many": true, "more": true, "most": true, "much": true,
+	"must": true, "only": true, "other": true, "over": true, "said": true, "same": true,
+	"should": true, "some": true, "such": true, "than": true, "that": true, "their": true,
+	"them": true, "then": true, "there": true, "these": true, "they": true, "this": true,
+	"those": true, "through": true, "under": true, "until": true, "very": true, "want": true,
+	"were": true, "what": true, "when": true, "where": true, "which": true, "while": true,
+	"will": true, "with": true, "would": true, "your": true, "yours": true, "it's": true,
+}
+
+// deriveKeywords returns up to ten of the most frequent significant words
+// in text, requiring each to appear at least twice
+func deriveKeywords(text string) []string {
+	const maxKeywords = 10
+
+	counts := map[string]int{}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if utf8.RuneCountInString(word) < 4 || keywordStopwords[word] || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			continue
+		}
+		counts[word]++
+	}
+
+	var keywords []string
+	for word, count := range counts {
+		if count >= 2 {
+			keywords = append(keywords, word)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+	if len(keywords) > maxKeywords {
+		keywords = keywords[:maxKeywords]
+	}
+	return keywords
+}
+
+// sentimentWords weighs common English opinion words by polarity
+var sentimentWords = map[string]int{
+	"amazing": 2, "awesome": 2, "beautiful": 2, "best": 2, "brilliant": 2, "delightful": 2,
+	"excellent": 2, "fantastic": 2, "great": 2, "love": 2, "loved": 2, "outstanding": 2,
+	"perfect": 2, "superb": 2, "wonderful": 2, "benefit": 1, "better": 1, "calm": 1,
+	"celebrate": 1, "clean": 1, "easy": 1, "effective": 1, "enjoy": 1, "enjoyed": 1,
+	"exciting": 1, "fair": 1, "fun": 1, "glad": 1, "good": 1, "happy": 1, "helpful": 1,
+	"hope": 1, "improve": 1, "improved": 1, "improvement": 1, "interesting": 1, "like": 1,
+	"nice": 1, "positive": 1, "progress": 1, "recommend": 1, "safe": 1, "success": 1,
+	"successful": 1, "support": 1, "win": 1, "won": 1,
+	"awful": -2, "catastrophic": -2, "dead": -2, "disaster": -2, "disgusting": -2, "hate": -2,
+	"hated": -2, "horrible": -2, "killed": -2, "terrible": -2, "tragic": -2, "worst": -2,
+	"angry": -1, "bad": -1, "broken": -1, "crisis": -1, "damage": -1, "danger": -1,
+	"dangerous": -1, "decline": -1, "difficult": -1, "disappointing": -1, "fail": -1,
+	"failed": -1, "failure": -1, "fear": -1, "hard": -1, "harm": -1, "loss": -1, "lost": -1,
+	"negative": -1, "pain": -1, "poor": -1, "problem": -1, "problems": -1, "risk": -1,
+	"sad": -1, "threat": -1, "worried": -1, "worse": -1, "wrong": -1,
+}
+
+// sentimentNegations flip the polarity of the following opinion word
+var sentimentNegations = map[string]bool{
+	"not": true, "no": true, "never": true, "isn't": true, "wasn't": true, "don't": true,
+	"doesn't": true, "didn't": true, "can't": true, "won't": true, "without": true,
+}
+
+// scoreSentiment scores text against sentimentWords, flipping words that
+// follow a negation within two words. Texts with fewer than three opinion
+// words, or a score within 0.2 of zero, are neutral
+func scoreSentiment(text string) *Sentiment {
+	var positive, negative, matches int
+	negatedFor := 0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' })
+		word = strings.ReplaceAll(word, "’", "'")
+		if sentimentNegations[word] {
+			negatedFor = 2
+			continue
+		}
+		weight := sentimentWords[word]
+		if negatedFor > 0 {
+			negatedFor--
+			weight = -weight
+		}
+		if weight == 0 {
+			continue
+		}
+		matches++
+		if weight > 0 {
+			positive += weight
+		} else {
+			negative -= weight
+		}
+	}
+
+	sentiment := &Sentiment{Label: "neutral"}
+	if matches < 3 {
+		return sentiment
+	}
+	sentiment.Score = math.Round(float64(positive-negative)/float64(positive+negative)*100) / 100
+	switch {
+	case sentiment.Score >= 0.2:
+		sentiment.Label = "positive"
+	case sentiment.Score <= -0.2:
+		sentiment.Label = "negative"
+	}
+	return sentiment
+}
+
+// sameDay reports whether two dates fall on the same day, comparing the
+// raw strings when either cannot be parsed
+func sameDay(a, b string) bool {
+	timeA, okA := parseDate(a)
+	timeB, okB := parseDate(b)
+	if !okA || !okB {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	return timeA.UTC().Format("2006-01-02") == timeB.UTC().Format("2006-01-02")
+}
+
+// dateLayouts lists the date formats recognised by normalizeDate
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"02 Jan 2006",
+	"2006/01/02",
+}
+
+// parseDate parses a publication date string in any recognised layout
+func parseDate(raw string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// normalizeDate converts a date string to ISO 8601, or "" when unparseable
+func normalizeDate(raw string) string {
+	t, ok := parseDate(raw)
+	if !ok {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// extractJSONLD parses every JSON-LD block into a flat list of objects,
+// expanding top-level arrays and @graph containers
+func extractJSONLD(doc *goquery.Document) []map[string]interface{} {
+	var objects []map[string]interface{}
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch value := v.(type) {
+		case []interface{}:
+			for _, item := range value {
+				collect(item)
+			}
+		case map[string]interface{}:
+			objects = append(objects, value)
+			if graph, ok := value["@graph"]; ok {
+				collect(graph)
+			}
+		}
+	}
+
+	doc.Find("script[type='application/ld+json']").Each(func(i int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err == nil {
+			collect(data)
+		}
+	})
+	return objects
+}
+
+// jsonLDHasType reports whether a JSON-LD object declares one of the types
+func jsonLDHasType(object map[string]interface{}, types ...string) bool {
+	var declared []string
+	switch value := object["@type"].(type) {
+	case string:
+		declared = []string{value}
+	case []interface{}:
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				declared = append(declared, name)
+			}
+		}
+	}
+	for _, name := range declared {
+		for _, want := range types {
+			if strings.EqualFold(name, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDValue walks nested JSON-LD properties, taking the first element
+// of any array along the way
+func jsonLDValue(v interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		if items, ok := v.([]interface{}); ok {
+			if len(items) == 0 {
+				return nil
+			}
+			v = items[0]
+		}
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = object[key]
+	}
+	return v
+}
+
+// jsonLDString returns a JSON-LD value as a string. Objects are reduced to
+// the first of the given properties that holds a string
+func jsonLDString(v interface{}, properties ...string) string {
+	switch value := v.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case []interface{}:
+		if len(value) > 0 {
+			return jsonLDString(value[0], properties...)
+		}
+	case map[string]interface{}:
+		for _, property := range properties {
+			if text := jsonLDString(value[property]); text != "" {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// resolveURL resolves a possibly relative reference against the page URL,
+// returning "" for empty or invalid references
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return resolved.String()
+}
+
+// breadcrumbSelector matches breadcrumb trails, which cleanDocument removes
+const breadcrumbSelector = "nav[aria-label='breadcrumb' i], nav[aria-label='breadcrumbs' i], .breadcrumb, .breadcrumbs"
+
+// extractSection returns the publication section or category, from
+// article:section, JSON-LD articleSection or the breadcrumb trail
+func extractSection(doc *goquery.Document, jsonLD []map[string]interface{}) string {
+	if section := strings.TrimSpace(doc.Find("meta[property='article:section']").First().AttrOr("content", "")); section != "" {
+		return section
+	}
+	for _, object := range jsonLD {
+		if section := jsonLDString(object["articleSection"]); section != "" {
+			return section
+		}
+	}
+
+	// The last breadcrumb is usually the article itself, so prefer the
+	// last linked crumb that is not the home page
+	var section string
+	doc.Find(breadcrumbSelector).First().Find("a").Each(func(i int, s *goquery.Selection) {
+		name := strings.Join(strings.Fields(s.Text()), " ")
+		if name != "" && !strings.EqualFold(name, "home") {
+			section = name
+		}
+	})
+	return section
+}
+
+// extractBreadcrumbs returns the breadcrumb trail from a schema.org
+// BreadcrumbList in JSON-LD or microdata, or from breadcrumb navigation
+func extractBreadcrumbs(doc *goquery.Document, jsonLD []map[string]interface{}, pageURL *url.URL) []Crumb {
+	for _, object := range jsonLD {
+		if !jsonLDHasType(object, "BreadcrumbList") {
+			continue
+		}
+		items, _ := object["itemListElement"].([]interface{})
+		type positioned struct {
+			position float64
+			crumb    Crumb
+		}
+		var steps []positioned
+		for i, item := range items {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name := jsonLDString(entry["name"])
+			if name == "" {
+				name = jsonLDString(entry["item"], "name")
+			}
+			if name == "" {
+				continue
+			}
+			position, ok := entry["position"].(float64)
+			if !ok {
+				position, _ = strconv.ParseFloat(jsonLDString(entry["position"]), 64)
+			}
+			if position == 0 {
+				position = float64(i + 1)
+			}
+			steps = append(steps, positioned{position, Crumb{
+				Name: name,
+				URL:  resolveURL(pageURL, jsonLDString(entry["item"], "@id", "url")),
+			}})
+		}
+		sort.SliceStable(steps, func(i, j int) bool { return steps[i].position < steps[j].position })
+		var trail []Crumb
+		for _, step := range steps {
+			trail = append(trail, step.crumb)
+		}
+		if len(trail) > 0 {
+			return trail
+		}
+	}
+
+	var trail []Crumb
+	add := func(name, href string) {
+		name = strings.Join(strings.Fields(name), " ")
+		if strings.Trim(name, "/>›»|·-") == "" {
+			return
+		}
+		trail = append(trail, Crumb{Name: name, URL: resolveURL(pageURL, href)})
+	}
+
+	doc.Find("[itemtype*='schema.org/BreadcrumbList'] [itemprop='itemListElement']").Each(func(i int, s *goquery.Selection) {
+		item := s.Find("[itemprop='item']").First()
+		name := s.Find("[itemprop='name']").First().AttrOr("content", s.Find("[itemprop='name']").First().Text())
+		add(name, item.AttrOr("href", item.AttrOr("content", "")))
+	})
+	if len(trail) > 0 {
+		return trail
+	}
+
+	// Navigation: one crumb per list item, or per link without a list
+	nav := doc.Find(breadcrumbSelector).First()
+	if items := nav.Find("li"); items.Length() > 0 {
+		items.Each(func(i int, s *goquery.Selection) {
+			add(s.Text(), s.Find("a[href]").First().AttrOr("href", ""))
+		})
+	} else {
+		nav.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+			add(s.Text(), s.AttrOr("href", ""))
+		})
+	}
+	return trail
+}
+
+// extractStylesheets returns the absolute URLs of the page's screen
+// stylesheets, in document order
+func extractStylesheets(doc *goquery.Document, pageURL *url.URL) []string {
+	var stylesheets []string
+	doc.Find("link[rel~='stylesheet' i][href]").Each(func(i int, s *goquery.Selection) {
+		rel := strings.ToLower(s.AttrOr("rel", ""))
+		media := strings.ToLower(strings.TrimSpace(s.AttrOr("media", "")))
+		if strings.Contains(rel, "alternate") || media == "print" || s.Is("[disabled]") {
+			return
+		}
+		if link := resolveURL(pageURL, s.AttrOr("href", "")); strings.HasPrefix(link, "http") {
+			stylesheets = append(stylesheets, link)
+		}
+	})
+	return stylesheets
+}
+
+// commentCountSelector matches visible comment counters in page chrome
+const commentCountSelector = ".comment-count, .comments-count, .comment-counter, .count-comments, .comments-link, a[href$='#comments'], a[href$='#respond']"
+
+// extractEngagement collects comment, like, share and view counts from
+// JSON-LD, microdata and common comment counters. It must run before
+// cleaning, which strips the page chrome these live in
+func extractEngagement(doc *goquery.Document, jsonLD []map[string]interface{}) *Engagement {
+	stats := &Engagement{}
+	record := func(kind string, count int) {
+		if count <= 0 {
+			return
+		}
+		kind = strings.ToLower(kind)
+		switch {
+		case strings.Contains(kind, "comment"):
+			if stats.Comments == 0 {
+				stats.Comments = count
+			}
+		case strings.Contains(kind, "like"):
+			if stats.Likes == 0 {
+				stats.Likes = count
+			}
+		case strings.Contains(kind, "share"):
+			if stats.Shares == 0 {
+				stats.Shares = count
+			}
+		case strings.Contains(kind, "view"), strings.Contains(kind, "visit"), strings.Contains(kind, "watch"), strings.Contains(kind, "read"):
+			if stats.Views == 0 {
+				stats.Views = count
+			}
+		}
+	}
+
+	for _, object := range jsonLD {
+		record("comment", parseCount(jsonLDString(object["commentCount"])))
+		statistics := object["interactionStatistic"]
+		if single, ok := statistics.(map[string]interface{}); ok {
+			statistics = []interface{}{single}
+		}
+		items, _ := statistics.([]interface{})
+		for _, item := range items {
+			kind := jsonLDString(jsonLDValue(item, "interactionType"), "@type", "@id")
+			record(path.Base(kind), parseCount(jsonLDString(jsonLDValue(item, "userInteractionCount"))))
+		}
+	}
+
+	// Microdata: commentCount, or the older interactionCount "UserComments:12"
+	doc.Find("[itemprop='commentCount']").Each(func(i int, s *goquery.Selection) {
+		record("comment", parseCount(s.AttrOr("content", s.Text())))
+	})
+	doc.Find("[itemprop='interactionCount']").Each(func(i int, s *goquery.Selection) {
+		if kind, count, ok := strings.Cut(s.AttrOr("content", ""), ":"); ok {
+			record(kind, parseCount(count))
+		}
+	})
+
+	if stats.Comments == 0 {
+		doc.Find("[data-comment-count], [data-comments-count]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			record("comment", parseCount(s.AttrOr("data-comment-count", s.AttrOr("data-comments-count", ""))))
+			return stats.Comments == 0
+		})
+	}
+	if stats.Comments == 0 {
+		doc.Find(commentCountSelector).EachWithBreak(func(i int, s *goquery.Selection) bool {
+			record("comment", parseCount(s.Text()))
+			return stats.Comments == 0
+		})
+	}
+
+	if *stats == (Engagement{}) {
+		return nil
+	}
+	return stats
+}
+
+// extractLocation returns the article's place from JSON-LD contentLocation
+// or locationCreated, falling back to the geo.position, ICBM,
+// place:location and geo.placename meta tags
+func extractLocation(doc *goquery.Document, jsonLD []map[string]interface{}) *Location {
+	location := &Location{}
+	setCoordinates := func(latitude, longitude string) {
+		if location.Latitude != nil {
+			return
+		}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(latitude), 64)
+		long, errLong := strconv.ParseFloat(strings.TrimSpace(longitude), 64)
+		if errLat == nil && errLong == nil && lat >= -90 && lat <= 90 && long >= -180 && long <= 180 {
+			location.Latitude, location.Longitude = &lat, &long
+		}
+	}
+
+	for _, object := range jsonLD {
+		for _, property := range []string{"contentLocation", "locationCreated"} {
+			place := object[property]
+			if place == nil {
+				continue
+			}
+			if location.Name == "" {
+				location.Name = jsonLDString(place, "name")
+			}
+			if location.Name == "" {
+				location.Name = jsonLDString(jsonLDValue(place, "address"), "addressLocality", "addressRegion", "addressCountry")
+			}
+			setCoordinates(jsonLDString(jsonLDValue(place, "geo", "latitude")), jsonLDString(jsonLDValue(place, "geo", "longitude")))
+		}
+	}
+
+	meta := func(name string) string {
+		return strings.TrimSpace(doc.Find("meta[name='"+name+"' i], meta[property='"+name+"' i]").First().AttrOr("content", ""))
+	}
+	// geo.position is "lat;long" and ICBM is "lat, long"
+	if latitude, longitude, ok := strings.Cut(meta("geo.position"), ";"); ok {
+		setCoordinates(latitude, longitude)
+	}
+	if latitude, longitude, ok := strings.Cut(meta("ICBM"), ","); ok {
+		setCoordinates(latitude, longitude)
+	}
+	setCoordinates(meta("place:location:latitude"), meta("place:location:longitude"))
+	if location.Name == "" {
+		location.Name = meta("geo.placename")
+	}
+
+	if location.Name == "" && location.Latitude == nil {
+		return nil
+	}
+	return location
+}
+
+// parseCount parses the first count in text such as "1,234 comments" or
+// "2.5K", returning 0 when there is none
+func parseCount(text string) int {
+	start := strings.IndexFunc(text, unicode.IsDigit)
+	if start < 0 {
+		return 0
+	}
+	end := start
+	for end < len(text) && (unicode.IsDigit(rune(text[end])) || text[end] == ',' || text[end] == '.') {
+		end++
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimRight(text[start:end], ".,"), ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+	if end < len(text) {
+		switch text[end] {
+		case 'k', 'K':
+			value *= 1e3
+		case 'm', 'M':
+			value *= 1e6
+		}
+	}
+	return int(value)
+}
+
+// extractLanguage returns the declared document language as a BCP 47 tag
+func extractLanguage(doc *goquery.Document) string {
+	languageSources := []struct {
+		selector string
+		attr     string
+	}{
+		{"html", "lang"},
+		{"html", "xml:lang"},
+		{"meta[http-equiv='content-language' i]", "content"},
+		{"meta[property='og:locale']", "content"},
+	}
+
+	for _, source := range languageSources {
+		if lang := strings.TrimSpace(doc.Find(source.selector).First().AttrOr(source.attr, "")); lang != "" {
+			// og:locale uses underscores and content-language may list several
+			lang = strings.TrimSpace(strings.Split(lang, ",")[0])
+			return strings.ReplaceAll(lang, "_", "-")
+		}
+	}
+	return ""
+}
+
+// rtlLanguages are primary language subtags written right-to-left
+var rtlLanguages = map[string]bool{
+	"ar": true, "he": true, "iw": true, "fa": true, "ur": true, "ps": true,
+	"yi": true, "dv": true, "ckb": true, "sd": true, "ug": true, "syr": true,
+}
+
+// detectDirection returns "rtl" or "ltr" from explicit dir attributes,
+// the document language or, failing both, the dominant script
+func detectDirection(doc *goquery.Document, content *goquery.Selection, language string) string {
+	for _, s := range []*goquery.Selection{content, doc.Find("body"), doc.Find("html")} {
+		switch strings.ToLower(s.AttrOr("dir", "")) {
+		case "rtl":
+			return "rtl"
+		case "ltr":
+			return "ltr"
+		}
+	}
+
+	if language != "" {
+		primary := strings.ToLower(strings.SplitN(language, "-", 2)[0])
+		if rtlLanguages[primary] {
+			return "rtl"
+		}
+		return "ltr"
+	}
+
+	var letters, rtl int
+	for _, r := range content.Text() {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko) {
+			rtl++
+		}
+	}
+	if letters > 0 && rtl*2 > letters {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// extractCanonicalURL returns the page's declared canonical URL, falling
+// back to og:url and then to the fetched URL
+func extractCanonicalURL(doc *goquery.Document, pageURL *url.URL) *url.URL {
+	for _, selector := range []string{"link[rel='canonical']", "meta[property='og:url']"} {
+		selection := doc.Find(selector).First()
+		ref := resolveURL(pageURL, selection.AttrOr("href", selection.AttrOr("content", "")))
+		if canonical, err := url.Parse(ref); ref != "" && err == nil && canonical.Hostname() != "" {
+			return canonical
+		}
+	}
+	return pageURL
+}
+
+// trackingParams are query parameters that identify a visit rather than
+// a page; parameters starting with utm_ are dropped too
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "_ga": true, "_gl": true, "yclid": true, "ref_src": true,
+}
+
+// articleID returns a stable SHA-256 key of the canonical URL, normalized
+// so that scheme, case of the host, a www. prefix, default ports, the
+// fragment, a trailing slash, tracking parameters and parameter order do
+// not change it
+func articleID(canonical *url.URL) string {
+	host := strings.TrimPrefix(strings.ToLower(canonical.Hostname()), "www.")
+	if port := canonical.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	urlPath := strings.TrimSuffix(canonical.EscapedPath(), "/")
+
+	query := canonical.Query()
+	for name := range query {
+		if trackingParams[strings.ToLower(name)] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			query.Del(name)
+		}
+	}
+	normalized := host + urlPath
+	if encoded := query.Encode(); encoded != "" {
+		normalized += "?" + encoded
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// extractDomain returns the publication's domain, preferring the
+// canonical URL's host over the fetched one
+func extractDomain(doc *goquery.Document, pageURL *url.URL) string {
+	host := pageURL.Hostname()
+	if canonical := resolveURL(pageURL, doc.Find("link[rel='canonical']").First().AttrOr("href", "")); canonical != "" {
+		if parsed, err := url.Parse(canonical); err == nil && parsed.Hostname() != "" {
+			host = parsed.Hostname()
+		}
+	}
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// extractLogo returns the publisher logo, falling back to the
+// apple-touch-icon
+func extractLogo(doc *goquery.Document, jsonLD []map[string]interface{}, pageURL *url.URL) string {
+	for _, object := range jsonLD {
+		if logo := jsonLDString(jsonLDValue(object, "publisher", "logo"), "url", "contentUrl", "@id"); logo != "" {
+			return resolveURL(pageURL, logo)
+		}
+		if jsonLDHasType(object, "Organization", "NewsMediaOrganization") {
+			if logo := jsonLDString(object["logo"], "url", "contentUrl", "@id"); logo != "" {
+				return resolveURL(pageURL, logo)
+			}
+		}
+	}
+
+	logoSelectors := []string{
+		"meta[property='og:logo']",
+		"link[rel='apple-touch-icon']",
+		"link[rel='apple-touch-icon-precomposed']",
+	}
+	for _, selector := range logoSelectors {
+		selection := doc.Find(selector).First()
+		if logo := selection.AttrOr("content", selection.AttrOr("href", "")); logo != "" {
+			return resolveURL(pageURL, logo)
+		}
+	}
+	return ""
+}
+
+// extractLeadImage returns the image declared by og:image, twitter:image or
+// JSON-LD, with dimensions from og:image:width/height or the JSON-LD
+// ImageObject
+func extractLeadImage(doc *goquery.Document, jsonLD []map[string]interface{}, pageURL *url.URL) *LeadImage {
+	for _, selector := range []string{"meta[property='og:image:secure_url']", "meta[property='og:image']", "meta[property='og:image:url']"} {
+		if src := resolveURL(pageURL, doc.Find(selector).First().AttrOr("content", "")); src != "" {
+			lead := &LeadImage{URL: src}
+			lead.setSize(
+				parseDimension(doc.Find("meta[property='og:image:width']").First().AttrOr("content", "")),
+				parseDimension(doc.Find("meta[property='og:image:height']").First().AttrOr("content", "")),
+			)
+			return lead
+		}
+	}
+	for _, object := range jsonLD {
+		if src := resolveURL(pageURL, jsonLDString(object["image"], "url", "contentUrl", "@id")); src != "" {
+			lead := &LeadImage{URL: src}
+			lead.setSize(
+				parseDimension(jsonLDString(jsonLDValue(object, "image", "width"), "value")),
+				parseDimension(jsonLDString(jsonLDValue(object, "image", "height"), "value")),
+			)
+			return lead
+		}
+	}
+	for _, selector := range []string{"meta[name='twitter:image']", "meta[property='twitter:image']"} {
+		if src := resolveURL(pageURL, doc.Find(selector).First().AttrOr("content", "")); src != "" {
+			return &LeadImage{URL: src}
+		}
+	}
+	return nil
+}
+
+// setSize records the image's dimensions when both are known
+func (lead *LeadImage) setSize(width, height float64) {
+	if width < 1 || height < 1 {
+		return
+	}
+	lead.Width, lead.Height = int(width), int(height)
+	lead.AspectRatio = math.Round(width/height*10000) / 10000
+}
+
+// completeLeadImage falls back to the first content image when the page
+// declares no lead image, and fills in unknown dimensions from the
+// matching content image's attributes or, with ProbeImageSize, the image
+// file itself
+func completeLeadImage(lead *LeadImage, content *goquery.Selection, pageURL *url.URL, config *Config) *LeadImage {
+	images := collectImages(content, pageURL)
+	if lead == nil {
+		if len(images) == 0 {
+			return nil
+		}
+		lead = &LeadImage{URL: images[0].URL}
+	}
+	if lead.Width > 0 {
+		return lead
+	}
+
+	content.Find("img").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if resolveURL(pageURL, s.AttrOr("src", "")) != lead.URL {
+			return true
+		}
+		lead.setSize(parseDimension(s.AttrOr("width", "")), parseDimension(s.AttrOr("height", "")))
+		return false
+	})
+	if lead.Width == 0 && config.ProbeImageSize {
+		src, err := url.Parse(lead.URL)
+		if err == nil && (!config.SameOriginImages || sameSite(src, pageURL)) {
+			lead.setSize(probeImageSize(lead.URL, config))
+		}
+	}
+	return lead
+}
+
+// maxImageProbe is the number of bytes fetched to read an image header
+const maxImageProbe = 64 * 1024
+
+// probeImageSize reads the dimensions of a PNG, JPEG or GIF from the
+// start of the file, returning zeros when they cannot be read
+func probeImageSize(imageURL string, config *Config) (float64, float64) {
+	client := &http.Client{Transport: sharedTransport(config), Timeout: config.RequestTimeout}
+	req, err := newRequest("GET", imageURL, config)
+	if err != nil {
+		return 0, 0
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", maxImageProbe-1))
+	resp, err := sendRequest(client, req, config.ConnectTimeout)
+	if err != nil {
+		return 0, 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, 0
+	}
+
+	imageConfig, _, err := image.DecodeConfig(io.LimitReader(resp.Body, maxImageProbe))
+	if err != nil {
+		return 0, 0
+	}
+	return float64(imageConfig.Width), float64(imageConfig.Height)
+}
+
+// extractThemeColor reads the theme-color meta tags. Color is the one
+// without a media query, falling back to the light and then the first
+// declared color
+func extractThemeColor(doc *goquery.Document) *ThemeColor {
+	var theme ThemeColor
+	var first string
+	doc.Find("meta[name='theme-color' i]").Each(func(i int, s *goquery.Selection) {
+		color := strings.TrimSpace(s.AttrOr("content", ""))
+		if color == "" {
+			return
+		}
+		if first == "" {
+			first = color
+		}
+		media := strings.ToLower(strings.Join(strings.Fields(s.AttrOr("media", "")), ""))
+		switch {
+		case media == "" && theme.Color == "":
+			theme.Color = color
+		case strings.Contains(media, "prefers-color-scheme:dark") && theme.Dark == "":
+			theme.Dark = color
+		case strings.Contains(media, "prefers-color-scheme:light") && theme.Light == "":
+			theme.Light = color
+		}
+	})
+	if first == "" {
+		return nil
+	}
+	if theme.Color == "" {
+		theme.Color = theme.Light
+	}
+	if theme.Color == "" {
+		theme.Color = first
+	}
+	return &theme
+}
+
+// extractDescription extracts page description
+func extractDescription(doc *goquery.Document) string {
+	descSelectors := []string{
+		"meta[property='og:description']",
+		"meta[name='description']",
+		"meta[name='twitter:description']",
+	}
+
+	for _, selector := range descSelectors {
+		if desc := doc.Find(selector).First().AttrOr("content", ""); desc != "" {
+			return strings.TrimSpace(desc)
+		}
+	}
+	return ""
+}
+
+// cleanDocument removes unwanted elements, failing with processing_timeout
+// once ctx's deadline passes. The deadline is checked between passes and
+// within the passes that visit every element
+func cleanDocument(ctx context.Context, doc *goquery.Document, config *Config) error {
+	// Caller exclusions run first so they never count towards content scoring
+	for _, selector := range config.ExcludeSelectors {
+		if selector = strings.TrimSpace(selector); selector != "" {
+			doc.Find(selector).Remove()
+		}
+	}
+
+	// Web-component shells keep their markup in <template> elements
+	promoteTemplateContent(doc)
+
+	// Convert AMP components so their media survives as standard HTML
+	convertAMPElements(doc)
+
+	// Drop tracking pixels first so only real images and text are
+	// recovered before <noscript> fallbacks are discarded
+	dropTrackingNoscripts(doc)
+	promoteNoscriptImages(doc)
+	promoteNoscriptContent(doc)
+
+	// Quote attributions often sit in a <footer>, which is removed below
+	preserveQuoteAttributions(doc)
+	// Pull quotes often use <aside>, so they are handled first too
+	handlePullQuotes(doc, config.PullQuotes)
+
+	unwantedSelectors := []string{"script", "style", "noscript", "iframe", "embed", "object"}
+	if config.RemoveChrome {
+		unwantedSelectors = append(unwantedSelectors,
+			"nav", "header", "footer", "aside",
+			".advertisement", ".ads", ".ad", ".social-share", ".social-sharing",
+			".comments", ".comment", ".sidebar", ".navigation", ".menu",
+			".banner", ".cookie-notice",
+			".screen-reader-text", ".visually-hidden",
+		)
+	}
+	if config.Strength == "aggressive" {
+		unwantedSelectors = append(unwantedSelectors, aggressiveSelectors...)
+	}
+
+	for _, selector := range unwantedSelectors {
+		doc.Find(selector).Remove()
+	}
+	if err := checkDeadline(ctx, "cleaning"); err != nil {
+		return err
+	}
+
+	if config.RemoveChrome {
+		removeModals(doc, config.Modals)
+		removeAriaHidden(doc, config.AriaHidden)
+	}
+
+	if !config.KeepForms {
+		removeForms(doc)
+	}
+
+	if config.RemoveChrome {
+		if err := removeAppBanners(ctx, doc); err != nil {
+			return err
+		}
+	}
+	cleanSVGs(doc, config.SVGMode)
+
+	// Remove suspicious content
+	if config.FuzzyRemoval {
+		phrases := config.BoilerplatePhrases
+		if config.Strength == "aggressive" {
+			phrases = append(append([]string{}, phrases...), aggressivePhrases...)
+		}
+		if err := removeSuspiciousContent(ctx, doc, phrases, config.LabelSponsored); err != nil {
+			return err
+		}
+	}
+	return checkDeadline(ctx, "cleaning")
+}
+
+// defaultBoilerplatePhrases mark advertising and sponsorship labels
+var defaultBoilerplatePhrases = []string{"advertisement", "sponsored"}
+
+// removeSuspiciousContent removes small leaf elements whose text contains
+// a boilerplate phrase or a cookie consent prompt, together with the
+// largest enclosing container that is still just as small. With label,
+// boilerplate containers are kept and given the reader-sponsored class
+func removeSuspiciousContent(ctx context.Context, doc *goquery.Document, extraPhrases []string, label bool) error {
+	const maxBoilerplateText = 200
+
+	var phrases []string
+	for _, phrase := range append(defaultBoilerplatePhrases, extraPhrases...) {
+		if phrase = strings.ToLower(strings.TrimSpace(phrase)); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	isCookiePrompt := func(text string) bool {
+		text = strings.ToLower(text)
+		return strings.Contains(text, "cookie") && strings.Contains(text, "accept")
+	}
+	isBoilerplate := func(text string) bool {
+		if isCookiePrompt(text) {
+			return true
+		}
+		text = strings.ToLower(text)
+		for _, phrase := range phrases {
+			if strings.Contains(text, phrase) {
+				return true
+			}
+		}
+		return false
+	}
+	textLength := func(s *goquery.Selection) int {
+		return len(strings.Join(strings.Fields(s.Text()), " "))
+	}
+
+	var blocks []string
+	for name := range blockElements {
+		blocks = append(blocks, name)
+	}
+	blockSelector := strings.Join(blocks, ", ")
+
+	var err error
+	doc.Find("body *").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if i%deadlineCheckInterval == 0 {
+			if err = checkDeadline(ctx, "cleaning"); err != nil {
+				return false
+			}
+		}
+		if s.Find(blockSelector).Length() > 0 || inCode(s) || s.Closest(".reader-sponsored").Length() > 0 {
+			return true
+		}
+		if length := textLength(s); length == 0 || length > maxBoilerplateText || !isBoilerplate(s.Text()) {
+			return true
+		}
+		// A phrase quoted in inline code is content, not boilerplate
+		if s.Find(codeSelector).Length() > 0 {
+			prose := s.Clone()
+			prose.Find(codeSelector).Remove()
+			if !isBoilerplate(prose.Text()) {
+				return true
+			}
+		}
+		target := outermostSmall(s, maxBoilerplateText)
+		if label && !isCookiePrompt(s.Text()) {
+			target.AddClass("reader-sponsored")
+			return true
+		}
+		target.Remove()
+		return true
+	})
+	return err
+}
+
+// codeSelector matches elements whose text is code, which must reach the
+// output byte-for-byte
+const codeSelector = "pre, code, kbd, samp"
+
+// inCode reports whether s is or lies within a code element, so that
+// phrase-based cleaning never removes tokens from code samples
+func inCode(s *goquery.Selection) bool {
+	return s.Closest(codeSelector).Length() > 0
+}
+
+// outermostSmall returns the largest ancestor of s, or s itself, whose
+// text is at most maxText characters, stopping below body
+func outermostSmall(s *goquery.Selection, maxText int) *goquery.Selection {
+	target := s
+	for parent := s.Parent(); parent.Length() > 0 && !parent.Is("body"); parent = parent.Parent() {
+		if len(strings.Join(strings.Fields(parent.Text()), " ")) > maxText || parent.Is(codeSelector) {
+			break
+		}
+		target = parent
+	}
+	return target
+}
+
+// modalSelector matches dialogs and the containers sites use for modals
+const modalSelector = "dialog, [role='dialog'], [aria-modal='true'], .modal, .popup, .overlay"
+
+// removeModals removes dialogs and modals according to mode, see
+// Config.Modals. In smart mode a modal holding at least half of the
+// page's text is kept, with a <dialog> turned into a <div> so its content
+// shows without the dialog being opened
+func removeModals(doc *goquery.Document, mode string) {
+	if mode == "keep" {
+		return
+	}
+	total := len(strings.Join(strings.Fields(doc.Find("body").Text()), " "))
+	doc.Find(modalSelector).Each(func(i int, s *goquery.Selection) {
+		// Modals nested in a removed one are already detached
+		if s.Closest("body").Length() == 0 {
+			return
+		}
+		if mode != "remove" && total > 0 && len(strings.Join(strings.Fields(s.Text()), " "))*2 >= total {
+			if node := s.Get(0); node.DataAtom == atom.Dialog {
+				s.RemoveAttr("open")
+				node.Data, node.DataAtom = "div", atom.Div
+			}
+			return
+		}
+		s.Remove()
+	})
+}
+
+// removeAriaHidden removes aria-hidden="true" elements according to mode,
+// see Config.AriaHidden. In smart mode an element is kept when it holds a
+// substantial block of text, which is real content rather than decoration
+func removeAriaHidden(doc *goquery.Document, mode string) {
+	const minHiddenContent = 200
+
+	if mode == "keep" {
+		return
+	}
+	doc.Find("[aria-hidden='true' i]").Each(func(i int, s *goquery.Selection) {
+		if mode != "remove" && len(strings.Join(strings.Fields(s.Text()), " ")) >= minHiddenContent {
+			s.RemoveAttr("aria-hidden")
+			return
+		}
+		s.Remove()
+	})
+}
+
+// removeForms drops form controls and small forms such as newsletter
+// signups, search boxes and polls. Forms with substantial text are
+// unwrapped instead, since some frameworks wrap the whole page in one
+func removeForms(doc *goquery.Document) {
+	const maxFormText = 500
+
+	doc.Find("form").Each(func(i int, s *goquery.Selection) {
+		if len(strings.Join(strings.Fields(s.Text()), " ")) <= maxFormText {
+			s.Remove()
+		} else {
+			s.Contents().Unwrap()
+		}
+	})
+	doc.Find("input, button, select, textarea, fieldset:empty").Remove()
+}
+
+// appBannerSelectors match "open in app" promotions by class or id
+const appBannerSelectors = ".smart-banner, .smartbanner, #smartbanner, .app-banner, .app-promo, .open-in-app, " +
+	"[class*='app-banner'], [class*='appbanner'], [class*='smartbanner'], [class*='open-in-app'], [class*='install-app'], " +
+	"[id*='app-banner'], [id*='open-in-app']"
+
+// appBannerPhrases are calls to switch to a native app
+var appBannerPhrases = []string{
+	"open in app", "open in the app", "open the app", "continue in app", "continue in the app",
+	"continue in our app", "continue reading in the app", "read in the app", "read in app",
+	"get the app", "download the app", "download our app", "install the app", "install our app",
+	"view in app", "switch to the app", "better in the app",
+}
+
+// removeAppBanners removes mobile "open in app" interstitials, matched by
+// class names or by short blocks with app prompts, which are usually
+// pinned with fixed or sticky positioning
+func removeAppBanners(ctx context.Context, doc *goquery.Document) error {
+	const maxBannerText = 300
+
+	doc.Find(appBannerSelectors).Each(func(i int, s *goquery.Selection) {
+		if len(strings.Join(strings.Fields(s.Text()), " ")) <= maxBannerText {
+			s.Remove()
+		}
+	})
+
+	var err error
+	doc.Find("body *").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if i%deadlineCheckInterval == 0 {
+			if err = checkDeadline(ctx, "cleaning"); err != nil {
+				return false
+			}
+		}
+		text := strings.ToLower(strings.Join(strings.Fields(s.Text()), " "))
+		if text == "" || len(text) > maxBannerText || inCode(s) {
+			return true
+		}
+		style := strings.ReplaceAll(strings.ToLower(s.AttrOr("style", "")), " ", "")
+		pinned := strings.Contains(style, "position:fixed") || strings.Contains(style, "position:sticky")
+		for _, phrase := range appBannerPhrases {
+			if strings.Contains(text, phrase) {
+				outermostSmall(s, maxBannerText).Remove()
+				return true
+			}
+		}
+		// Pinned blocks mentioning the app store are promos even without a stock phrase
+		if pinned && (strings.Contains(text, "app store") || strings.Contains(text, "google play")) {
+			s.Remove()
+		}
+		return true
+	})
+	return err
+}
+
+// promoteTemplateContent unwraps <template> elements, including declarative
+// shadow roots, when the page's rendered text is too short to be the
+// article, so script-hydrated content can be extracted
+func promoteTemplateContent(doc *goquery.Document) {
+	const minVisibleText = 200
+
+	body := doc.Find("body")
+	templates := body.Find("template")
+	if templates.Length() == 0 {
+		return
+	}
+	bodyHTML, err := body.Html()
+	if err != nil || len(extractPlainText(bodyHTML)) >= minVisibleText {
+		return
+	}
+
+	// Innermost first, so nested templates are unwrapped before their parents
+	for i := templates.Length() - 1; i >= 0; i-- {
+		fragment := templates.Eq(i)
+		if strings.TrimSpace(fragment.Text()) == "" && fragment.Find("img, picture, video").Length() == 0 {
+			continue
+		}
+		fragment.Contents().Unwrap()
+	}
+}
+
+// ampMediaAttributes lists the attributes carried over from AMP components
+var ampMediaAttributes = map[string]bool{
+	"src": true, "srcset": true, "sizes": true, "alt": true, "title": true,
+	"width": true, "height": true, "poster": true, "loop": true, "muted": true,
+}
+
+// convertAMPElements rewrites common AMP custom elements into their
+// standard HTML equivalents
+func convertAMPElements(doc *goquery.Document) {
+	doc.Find("amp-img, amp-anim").Each(func(i int, s *goquery.Selection) {
+		img := &html.Node{Type: html.ElementNode, Data: "img", DataAtom: atom.Img}
+		img.Attr = ampAttributes(s.Nodes[0])
+		s.ReplaceWithNodes(img)
+	})
+
+	doc.Find("amp-video, amp-audio").Each(func(i int, s *goquery.Selection) {
+		node := s.Nodes[0]
+		if node.Data == "amp-video" {
+			node.Data, node.DataAtom = "video", atom.Video
+		} else {
+			node.Data, node.DataAtom = "audio", atom.Audio
+		}
+		node.Attr = append(ampAttributes(node), html.Attribute{Key: "controls"})
+		// Only real media sources survive; AMP fallbacks and placeholders go
+		s.Children().Not("source, track").Remove()
+	})
+
+	doc.Find("amp-youtube[data-videoid]").Each(func(i int, s *goquery.Selection) {
+		videoURL := "https://www.youtube.com/watch?v=" + url.QueryEscape(s.AttrOr("data-videoid", ""))
+		s.ReplaceWithHtml(fmt.Sprintf(`<p><a href="%s">%s</a></p>`, html.EscapeString(videoURL), html.EscapeString(videoURL)))
+	})
+}
+
+// ampAttributes returns the media attributes worth keeping from an AMP node
+func ampAttributes(node *html.Node) []html.Attribute {
+	var attrs []html.Attribute
+	for _, attr := range node.Attr {
+		if ampMediaAttributes[attr.Key] {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// trackingPixelMarkers identify analytics beacons served as images
+var trackingPixelMarkers = []string{
+	"facebook.com/tr", "google-analytics.com", "googletagmanager.com", "doubleclick.net",
+	"scorecardresearch.com", "quantserve.com", "bat.bing.com", "analytics.twitter.com",
+	"px.ads.linkedin.com", "sb.scorecardresearch", "/pixel?", "/beacon?", "/collect?",
+}
+
+// isTrackingPixel reports whether an image is an analytics beacon: a
+// known tracker or an image declared at most 1px in either dimension
+func isTrackingPixel(img *goquery.Selection) bool {
+	src := strings.ToLower(img.AttrOr("src", ""))
+	for _, marker := range trackingPixelMarkers {
+		if strings.Contains(src, marker) {
+			return true
+		}
+	}
+	return isTinyImage(img, 2)
+}
+
+// dropTrackingNoscripts removes <noscript> blocks holding nothing but
+// tracking pixels, so they are neither promoted nor counted as content
+func dropTrackingNoscripts(doc *goquery.Document) {
+	doc.Find("noscript").Each(func(i int, s *goquery.Selection) {
+		fallback, err := goquery.NewDocumentFromReader(strings.NewReader(s.Text()))
+		if err != nil {
+			return
+		}
+		images := fallback.Find("img")
+		if images.Length() == 0 || strings.TrimSpace(fallback.Text()) != "" {
+			return
+		}
+		if images.Length() == images.FilterFunction(func(i int, img *goquery.Selection) bool {
+			return isTrackingPixel(img)
+		}).Length() {
+			s.Remove()
+		}
+	})
+}
+
+// noscriptPromptMarkers identify fallbacks that only ask for JavaScript
+var noscriptPromptMarkers = []string{"javascript", "browser", "enable", "cookies"}
+
+// promoteNoscriptContent keeps substantial text from <noscript> fallbacks,
+// as on sites that render the article with scripts. Short notices and
+// "please enable JavaScript" prompts are left for cleaning to remove
+func promoteNoscriptContent(doc *goquery.Document) {
+	const minTextLength = 200
+
+	doc.Find("noscript").Each(func(i int, s *goquery.Selection) {
+		fallback, err := goquery.NewDocumentFromReader(strings.NewReader(s.Text()))
+		if err != nil {
+			return
+		}
+		body := fallback.Find("body")
+		body.Find("script, style, iframe, img").FilterFunction(func(i int, el *goquery.Selection) bool {
+			return goquery.NodeName(el) != "img" || isTrackingPixel(el)
+		}).Remove()
+
+		text := strings.Join(strings.Fields(body.Text()), " ")
+		if len(text) < minTextLength {
+			return
+		}
+		lead := strings.ToLower(text[:minTextLength])
+		for _, marker := range noscriptPromptMarkers {
+			if strings.Contains(lead, marker) {
+				return
+			}
+		}
+		if contentHTML, err := body.Html(); err == nil {
+			s.ReplaceWithHtml(contentHTML)
+		}
+	})
+}
+
+// promoteNoscriptImages replaces lazy-loading placeholders with the real
+// images sites provide in <noscript> fallbacks
+func promoteNoscriptImages(doc *goquery.Document) {
+	doc.Find("noscript").Each(func(i int, s *goquery.Selection) {
+		// With scripting enabled the parser keeps <noscript> content as raw text
+		fallback, err := goquery.NewDocumentFromReader(strings.NewReader(s.Text()))
+		if err != nil {
+			return
+		}
+		images := fallback.Find("img").FilterFunction(func(i int, img *goquery.Selection) bool {
+			return hasUsableSrc(img) && !isTrackingPixel(img)
+		})
+		if images.Length() == 0 {
+			return
+		}
+
+		// The lazy image is usually the preceding sibling or wrapped in it
+		lazy := s.Prev().Filter("img")
+		if lazy.Length() == 0 {
+			lazy = s.Prev().Find("img")
+		}
+		if lazy.Length() != 1 {
+			lazy = nil
+		} else if hasUsableSrc(lazy) {
+			// A working image that is the same picture makes the fallback redundant
+			if sameImage(lazy, images.First()) {
+				s.Remove()
+				return
+			}
+			lazy = nil
+		}
+
+		var imagesHTML strings.Builder
+		images.Each(func(i int, img *goquery.Selection) {
+			if markup, err := goquery.OuterHtml(img); err == nil {
+				imagesHTML.WriteString(markup)
+			}
+		})
+		if lazy != nil {
+			lazy.ReplaceWithHtml(imagesHTML.String())
+			s.Remove()
+		} else {
+			s.ReplaceWithHtml(imagesHTML.String())
+		}
+	})
+}
+
+// sameImage reports whether two images reference the same file, looking
+// at the lazy-loading attributes of the first
+func sameImage(img, other *goquery.Selection) bool {
+	name := path.Base(strings.SplitN(other.AttrOr("src", ""), "?", 2)[0])
+	if name == "" || name == "." || name == "/" {
+		return false
+	}
+	for _, attr := range []string{"src", "data-src", "data-lazy-src", "data-original", "srcset", "data-srcset"} {
+		if strings.Contains(img.AttrOr(attr, ""), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUsableSrc reports whether an image has a real source rather than a
+// lazy-loading placeholder
+func hasUsableSrc(img *goquery.Selection) bool {
+	src := strings.ToLower(strings.TrimSpace(img.AttrOr("src", "")))
+	if src == "" || strings.HasPrefix(src, "data:") {
+		return false
+	}
+	for _, marker := range []string{"placeholder", "blank.gif", "spacer.gif", "transparent.gif", "lazy"} {
+		if strings.Contains(src, marker) {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanSVGs removes inline SVG icons while keeping illustrations
+func cleanSVGs(doc *goquery.Document, mode string) {
+	switch mode {
+	case "keep":
+		return
+	case "strip":
+		doc.Find("svg").Remove()
+		return
+	}
+
+	doc.Find("svg").Each(func(i int, s *goquery.Selection) {
+		if isIconSVG(s) {
+			s.Remove()
+		}
+	})
+}
+
+// isIconSVG reports whether an SVG looks like a small UI icon
+func isIconSVG(s *goquery.Selection) bool {
+	const iconSize = 48
+
+	// Many drawing elements indicate a chart or diagram regardless of size
+	elements := s.Find("*").Length()
+	if elements >= 25 {
+		return false
+	}
+
+	width := parseDimension(s.AttrOr("width", ""))
+	height := parseDimension(s.AttrOr("height", ""))
+	if width > 0 || height > 0 {
+		return width <= iconSize && height <= iconSize
+	}
+
+	viewBox := s.AttrOr("viewBox", s.AttrOr("viewbox", ""))
+	if fields := strings.Fields(strings.ReplaceAll(viewBox, ",", " ")); len(fields) == 4 {
+		vw, errW := strconv.ParseFloat(fields[2], 64)
+		vh, errH := strconv.ParseFloat(fields[3], 64)
+		if errW == nil && errH == nil {
+			return vw <= iconSize && vh <= iconSize
+		}
+	}
+
+	// Unsized SVGs with a handful of elements (often a single <use>) are icons
+	return elements < 5
+}
+
+// parseDimension parses a width/height attribute in pixels, returning 0
+// for missing or relative values
+func parseDimension(value string) float64 {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "px")
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// minMainContentLength is the text length below which a candidate is not
+// taken as the main content
+const minMainContentLength = 100
+
+// extractMainContent finds main content, preferring the caller's
+// prioritised selectors over the scored defaults
+func extractMainContent(doc *goquery.Document, config *Config) *goquery.Selection {
+	for _, selector := range config.ContentSelectors {
+		if selector = strings.TrimSpace(selector); selector == "" {
+			continue
+		}
+		if selection, length := longestMatch(doc.Selection, selector); selection != nil && length >= minMainContentLength {
+			return selection
+		}
+	}
+	if config.ReplaceContentSelectors {
+		return fallbackContent(doc)
+	}
+
+	// Landmark selectors are weighted so the document's declared main
+	// region wins over similarly sized generic containers
+	weights := config.Scoring
+	contentSelectors := []struct {
+		selector string
+		weight   float64
+	}{
+		{"main", weights.Landmark}, {"[role='main']", weights.Landmark},
+		{"article", weights.Article}, {"[role='article']", weights.Article}, {"[role='document']", weights.Document},
+		{".post-content", weights.Generic}, {".entry-content", weights.Generic}, {".article-content", weights.Generic},
+		{".content", weights.Generic}, {"#content", weights.Generic}, {"#main", weights.Generic},
+		{".post", weights.Generic}, {".entry", weights.Generic}, {".article", weights.Generic},
+		{"section", weights.Generic},
+	}
+
+	var contentSelection *goquery.Selection
+	var maxLength int
+	var maxScore float64
+
+	for _, candidate := range contentSelectors {
+		selection, length := longestMatch(doc.Selection, candidate.selector)
+		if selection == nil {
+			continue
+		}
+		if score := weights.score(selection, length, candidate.weight); score > maxScore {
+			maxScore = score
+			maxLength = length
+			contentSelection = selection
+		}
+	}
+
+	if contentSelection == nil || maxLength < minMainContentLength {
+		return fallbackContent(doc)
+	}
+
+	return contentSelection
+}
+
+// fallbackContent returns the body without page chrome, for pages where
+// no selector finds the main content
+func fallbackContent(doc *goquery.Document) *goquery.Selection {
+	body := doc.Find("body")
+	body.Find("header, footer, nav, aside, .sidebar, .navigation, .menu").Remove()
+	return body
+}
+
+// processContent applies post-extraction passes to the main content,
+// resolving links against the final (post-redirect) page URL
+func processContent(content *goquery.Selection, pageURL *url.URL, config *Config) {
+	flattenDeepHeadings(content, config.MaxHeadingDepth)
+	assignHeadingIDs(content)
+	linkFootnotes(content, pageURL, config.FootnoteTooltips)
+	annotateAbbreviations(content, config.ExpandAbbreviations)
+	decodeProtectedEmails(content)
+	formatQuoteCitations(content, pageURL)
+
+	if config.RemoveLinkClusters {
+		removeLinkClusters(content)
+	}
+
+	if config.TrimEdges {
+		trimBoilerplateEdges(content, config.EdgePhrases)
+	}
+
+	if config.Linkify {
+		linkifyText(content)
+	}
+
+	if config.AnnotateLinks {
+		annotateLinks(content, pageURL)
+	}
+
+	if config.BreakParagraphs {
+		splitBreakParagraphs(content)
+	}
+
+	if config.MergeBrokenLines {
+		mergeBrokenLines(content)
+	}
+
+	if config.ExpandClamped {
+		expandClampedText(content)
+	}
+
+	removeTinyImages(content, config.MinImageSize)
+
+	if len(config.AllowedImageTypes) > 0 {
+		filterImageTypes(content, pageURL, config.AllowedImageTypes, messagesFor(config.UILanguage))
+	}
+
+	if config.SameOriginImages {
+		blockThirdPartyImages(content, pageURL, messagesFor(config.UILanguage))
+	}
+
+	if len(config.StripImageParams) > 0 {
+		stripImageParams(content, config.StripImageParams)
+	}
+
+	if config.Typography == "typographic" || config.Typography == "ascii" {
+		normalizeTypography(content, config.Typography == "ascii")
+	}
+
+	if len(config.Highlights) > 0 {
+		highlightPhrases(content, config.Highlights)
+	}
+}
+
+// sameSite reports whether link is served from the page's host or one of
+// its subdomains, ignoring a leading "www."
+func sameSite(link, pageURL *url.URL) bool {
+	siteHost := strings.TrimPrefix(strings.ToLower(pageURL.Hostname()), "www.")
+	host := strings.TrimPrefix(strings.ToLower(link.Hostname()), "www.")
+	return host == siteHost || strings.HasSuffix(host, "."+siteHost)
+}
+
+// stripImageParams removes the given query parameters, matched without
+// regard to case, from image sources and responsive candidates. A "*"
+// parameter removes the whole query
+func stripImageParams(content *goquery.Selection, params []string) {
+	strip := make(map[string]bool, len(params))
+	for _, param := range params {
+		strip[strings.ToLower(strings.TrimSpace(param))] = true
+	}
+	clean := func(ref string) string {
+		imageURL, err := url.Parse(ref)
+		if err != nil || imageURL.RawQuery == "" || imageURL.Scheme == "data" {
+			return ref
+		}
+		if strip["*"] {
+			imageURL.RawQuery = ""
+			return imageURL.String()
+		}
+		// Untouched queries keep their order, which signatures may cover
+		query := imageURL.Query()
+		stripped := false
+		for name := range query {
+			if strip[strings.ToLower(name)] {
+				query.Del(name)
+				stripped = true
+			}
+		}
+		if !stripped {
+			return ref
+		}
+		imageURL.RawQuery = query.Encode()
+		return imageURL.String()
+	}
+
+	content.Find("img[src]").Each(func(i int, s *goquery.Selection) {
+		s.SetAttr("src", clean(s.AttrOr("src", "")))
+	})
+	content.Find("picture source[srcset], img[srcset]").Each(func(i int, s *goquery.Selection) {
+		candidates := strings.Split(s.AttrOr("srcset", ""), ",")
+		for j, candidate := range candidates {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+			fields[0] = clean(fields[0])
+			candidates[j] = strings.Join(fields, " ")
+		}
+		s.SetAttr("srcset", strings.Join(candidates, ", "))
+	})
+}
+
+// blockThirdPartyImages replaces off-site images with a link to the image
+// and drops off-site responsive candidates, keeping data URIs
+func blockThirdPartyImages(content *goquery.Selection, pageURL *url.URL, messages uiMessages) {
+	thirdParty := func(ref string) bool {
+		link, err := pageURL.Parse(strings.TrimSpace(ref))
+		return err == nil && link.Scheme != "data" && !sameSite(link, pageURL)
+	}
+
+	content.Find("picture source[srcset], img[srcset]").Each(func(i int, s *goquery.Selection) {
+		for _, candidate := range strings.Split(s.AttrOr("srcset", ""), ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 && thirdParty(fields[0]) {
+				if goquery.NodeName(s) == "source" {
+					s.Remove()
+				} else {
+					s.RemoveAttr("srcset")
+					s.RemoveAttr("sizes")
+				}
+				return
+			}
+		}
+	})
+
+	content.Find("img").Each(func(i int, s *goquery.Selection) {
+		src := s.AttrOr("src", "")
+		if src == "" || !thirdParty(src) {
+			return
+		}
+		replaceImageWithLink(s, pageURL, messages.ExternalImage)
+	})
+}
+
+// replaceImageWithLink swaps an image for a link to it, labelled with its
+// alt text or the fallback
+func replaceImageWithLink(img *goquery.Selection, pageURL *url.URL, fallback string) {
+	label := strings.TrimSpace(img.AttrOr("alt", ""))
+	if label == "" {
+		label = fallback
+	}
+	href := resolveURL(pageURL, img.AttrOr("src", ""))
+	img.ReplaceWithHtml(`<a class="reader-blocked-image" href="` + html.EscapeString(href) + `">` + html.EscapeString(label) + `</a>`)
+}
+
+// imageTypeFilter matches image URLs against allowed extensions and MIME
+// types. URLs whose type cannot be determined are allowed
+type imageTypeFilter struct {
+	extensions map[string]bool
+	mimeTypes  map[string]bool
+}
+
+// newImageTypeFilter builds a filter from entries such as "png", ".webp"
+// or "image/jpeg"
+func newImageTypeFilter(allowed []string) *imageTypeFilter {
+	filter := &imageTypeFilter{extensions: map[string]bool{}, mimeTypes: map[string]bool{}}
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if strings.Contains(entry, "/") {
+			filter.mimeTypes[entry] = true
+			continue
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		filter.extensions[entry] = true
+		// Aliases such as jpg and jpeg share a MIME type
+		if mimeType := mime.TypeByExtension("." + entry); mimeType != "" {
+			filter.mimeTypes[strings.SplitN(mimeType, ";", 2)[0]] = true
+		}
+	}
+	return filter
+}
+
+// allows reports whether the image at ref is of an allowed type, judged
+// by data URI type, format query hints or path extension
+func (f *imageTypeFilter) allows(ref string, pageURL *url.URL) bool {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(strings.ToLower(ref), "data:") {
+		mimeType := strings.ToLower(strings.SplitN(strings.SplitN(ref[5:], ",", 2)[0], ";", 2)[0])
+		return mimeType == "" || f.mimeTypes[mimeType]
+	}
+	link, err := pageURL.Parse(ref)
+	if err != nil {
+		return true
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(link.Path), "."))
+	// Image CDNs often name the output format in the query
+	for _, key := range []string{"format", "fm"} {
+		if format := strings.ToLower(link.Query().Get(key)); format != "" {
+			ext = format
+		}
+	}
+	if ext == "" {
+		return true
+	}
+	mimeType := strings.SplitN(mime.TypeByExtension("."+ext), ";", 2)[0]
+	if !strings.HasPrefix(mimeType, "image/") {
+		return true
+	}
+	return f.extensions[ext] || f.mimeTypes[mimeType]
+}
+
+// filterImageTypes replaces images of disallowed types with links and
+// drops disallowed responsive sources
+func filterImageTypes(content *goquery.Selection, pageURL *url.URL, allowed []string, messages uiMessages) {
+	filter := newImageTypeFilter(allowed)
+
+	content.Find("picture source").Each(func(i int, s *goquery.Selection) {
+		if sourceType := strings.ToLower(s.AttrOr("type", "")); sourceType != "" {
+			if !filter.mimeTypes[sourceType] {
+				s.Remove()
+			}
+			return
+		}
+		if fields := strings.Fields(s.AttrOr("srcset", "")); len(fields) > 0 && !filter.allows(fields[0], pageURL) {
+			s.Remove()
+		}
+	})
+
+	content.Find("img").Each(func(i int, s *goquery.Selection) {
+		if src := s.AttrOr("src", ""); src != "" && !filter.allows(src, pageURL) {
+			replaceImageWithLink(s, pageURL, messages.Image)
+			return
+		}
+		for _, candidate := range strings.Split(s.AttrOr("srcset", ""), ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 && !filter.allows(fields[0], pageURL) {
+				s.RemoveAttr("srcset")
+				s.RemoveAttr("sizes")
+				return
+			}
+		}
+	})
+}
+
+// cfEmailProtectionPath is the link Cloudflare substitutes for obfuscated
+// mailto links, with the encoded address in the fragment
+const cfEmailProtectionPath = "/cdn-cgi/l/email-protection"
+
+// decodeCFEmail decodes a Cloudflare data-cfemail value: hex bytes where
+// the first is an XOR key for the rest
+func decodeCFEmail(encoded string) (string, bool) {
+	data, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(data) < 2 {
+		return "", false
+	}
+	decoded := make([]byte, len(data)-1)
+	for i, b := range data[1:] {
+		decoded[i] = b ^ data[0]
+	}
+	email := string(decoded)
+	if !utf8.ValidString(email) || !strings.Contains(email, "@") {
+		return "", false
+	}
+	return email, true
+}
+
+// annotateAbbreviations gives untitled <abbr> elements the expansion of an
+// earlier or later use of the same abbreviation, and with markFirst marks
+// the first titled use of each with reader-abbr-first
+func annotateAbbreviations(content *goquery.Selection, markFirst bool) {
+	abbrs := content.Find("abbr")
+	expansions := map[string]string{}
+	abbrs.Each(func(i int, s *goquery.Selection) {
+		key := strings.TrimSpace(s.Text())
+		if title := strings.TrimSpace(s.AttrOr("title", "")); title != "" && expansions[key] == "" {
+			expansions[key] = title
+		}
+	})
+
+	marked := map[string]bool{}
+	abbrs.Each(func(i int, s *goquery.Selection) {
+		key := strings.TrimSpace(s.Text())
+		if strings.TrimSpace(s.AttrOr("title", "")) == "" && expansions[key] != "" {
+			s.SetAttr("title", expansions[key])
+		}
+		if markFirst && expansions[key] != "" && !marked[key] {
+			marked[key] = true
+			s.AddClass("reader-abbr-first")
+		}
+	})
+}
+
+// decodeProtectedEmails restores addresses hidden by Cloudflare email
+// protection, replacing "[email protected]" placeholders with mailto links
+func decodeProtectedEmails(content *goquery.Selection) {
+	content.Find("a[href*='" + cfEmailProtectionPath + "']").Each(func(i int, s *goquery.Selection) {
+		href := s.AttrOr("href", "")
+		encoded := ""
+		if idx := strings.Index(href, "#"); idx >= 0 {
+			encoded = href[idx+1:]
+		}
+		if encoded == "" {
+			encoded = s.Find("[data-cfemail]").AttrOr("data-cfemail", s.AttrOr("data-cfemail", ""))
+		}
+		if email, ok := decodeCFEmail(encoded); ok {
+			s.SetAttr("href", "mailto:"+email)
+			if s.Find("[data-cfemail]").Length() == 0 && strings.Contains(s.Text(), "protected") {
+				s.SetText(email)
+			}
+			s.RemoveAttr("data-cfemail")
+			s.RemoveClass("__cf_email__")
+		}
+	})
+
+	content.Find("[data-cfemail]").Each(func(i int, s *goquery.Selection) {
+		email, ok := decodeCFEmail(s.AttrOr("data-cfemail", ""))
+		if !ok {
+			return
+		}
+		escaped := html.EscapeString(email)
+		switch {
+		case goquery.NodeName(s) == "a":
+			s.SetAttr("href", "mailto:"+email)
+			s.SetText(email)
+			s.RemoveAttr("data-cfemail")
+			s.RemoveClass("__cf_email__")
+		case s.ParentsFiltered("a").Length() > 0:
+			s.ReplaceWithHtml(escaped)
+		default:
+			s.ReplaceWithHtml(`<a href="mailto:` + escaped + `">` + escaped + `</a>`)
+		}
+	})
+}
+
+// attributionDashes lead a quote attribution such as "— Ada Lovelace"
+const attributionDashes = "-–—~ \t\n"
+
+// pullQuoteSelector matches pull quotes in common CMS markup
+const pullQuoteSelector = ".pullquote, .pull-quote, .pull_quote, .wp-block-pullquote, [class*='pullquote'], [class*='pull-quote'], [data-pullquote]"
+
+// handlePullQuotes removes or restyles pull quotes according to mode, see
+// Config.PullQuotes. Kept pull quotes become a .reader-pullquote <div>,
+// distinct from blockquotes and safe from <aside> removal
+func handlePullQuotes(doc *goquery.Document, mode string) {
+	normalize := func(text string) string {
+		text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+		return strings.Trim(text, ` "'“”‘’«»…`)
+	}
+	bodyText := normalize(doc.Find("body").Text())
+
+	doc.Find(pullQuoteSelector).Each(func(i int, s *goquery.Selection) {
+		if s.ParentsFiltered(pullQuoteSelector).Length() > 0 {
+			return
+		}
+		quote := normalize(s.Find("blockquote, p").First().Text())
+		if quote == "" {
+			quote = normalize(s.Text())
+		}
+		// The pull quote itself accounts for one occurrence
+		if mode == "remove" || quote == "" || (mode != "style" && strings.Count(bodyText, quote) > 1) {
+			s.Remove()
+			return
+		}
+
+		inner, err := s.Html()
+		if err != nil {
+			return
+		}
+		if blockquote := s.Find("blockquote").First(); blockquote.Length() > 0 {
+			inner, _ = blockquote.Html()
+		}
+		s.ReplaceWithHtml(`<div class="reader-pullquote">` + inner + `</div>`)
+	})
+}
+
+// preserveQuoteAttributions marks a trailing <footer>, <cite> or
+// cite-only paragraph in a blockquote as the quote's attribution
+func preserveQuoteAttributions(doc *goquery.Document) {
+	doc.Find("blockquote").Each(func(i int, s *goquery.Selection) {
+		last := s.Children().Last()
+		if last.Length() == 0 {
+			return
+		}
+		for next := last.Nodes[0].NextSibling; next != nil; next = next.NextSibling {
+			if next.Type != html.TextNode || strings.TrimSpace(next.Data) != "" {
+				return
+			}
+		}
+
+		var inner string
+		switch goquery.NodeName(last) {
+		case "footer":
+			inner, _ = last.Html()
+		case "cite":
+			inner, _ = goquery.OuterHtml(last)
+		case "p":
+			cite := last.Children()
+			text := strings.TrimLeft(strings.TrimSpace(last.Text()), attributionDashes)
+			if cite.Length() != 1 || goquery.NodeName(cite) != "cite" || text != strings.TrimSpace(cite.Text()) {
+				return
+			}
+			inner, _ = last.Html()
+		default:
+			return
+		}
+		last.ReplaceWithHtml(`<div class="reader-quote-attribution">` + inner + `</div>`)
+	})
+}
+
+// formatQuoteCitations renders each blockquote's attribution as a line
+// below the quote, linking the blockquote's cite URL when it is a web
+// address. A quote with a cite URL but no attribution names the site
+func formatQuoteCitations(content *goquery.Selection, pageURL *url.URL) {
+	content.Find("blockquote").Each(func(i int, s *goquery.Selection) {
+		var source *url.URL
+		if ref := strings.TrimSpace(s.AttrOr("cite", "")); ref != "" {
+			if link, err := pageURL.Parse(ref); err == nil && (link.Scheme == "http" || link.Scheme == "https") {
+				source = link
+			}
+		}
+
+		attribution := s.ChildrenFiltered(".reader-quote-attribution").Last()
+		if attribution.Length() == 0 {
+			if source == nil {
+				return
+			}
+			s.AppendHtml(`<div class="reader-quote-attribution"></div>`)
+			attribution = s.ChildrenFiltered(".reader-quote-attribution").Last()
+			attribution.SetText(strings.TrimPrefix(source.Hostname(), "www."))
+		}
+
+		if first := attribution.Contents().First(); first.Length() > 0 && first.Nodes[0].Type == html.TextNode {
+			first.Nodes[0].Data = strings.TrimLeft(first.Nodes[0].Data, attributionDashes)
+		}
+		if strings.TrimLeft(strings.TrimSpace(attribution.Text()), attributionDashes) == "" {
+			attribution.Remove()
+			return
+		}
+
+		if source != nil && attribution.Find("a").Length() == 0 {
+			inner, _ := attribution.Html()
+			attribution.SetHtml(`<a href="` + html.EscapeString(source.String()) + `">` + inner + `</a>`)
+		}
+		attribution.PrependHtml("&mdash; ")
+	})
+}
+
+// linkClusterSelector matches containers that may hold a link cluster
+const linkClusterSelector = "ul, ol, div, section, aside, nav, table"
+
+// removeLinkClusters removes "related articles" style blocks by structure
+// rather than class name: several short links making up most of the
+// block's text, either with thumbnails or trailing the article
+func removeLinkClusters(content *goquery.Selection) {
+	const (
+		minLinks        = 3
+		minLinkDensity  = 0.7
+		maxAverageTitle = 100
+	)
+
+	textLength := func(s *goquery.Selection) int {
+		return len(strings.Join(strings.Fields(s.Text()), " "))
+	}
+
+	content.Find(linkClusterSelector).Each(func(i int, s *goquery.Selection) {
+		// Skip blocks already removed with an enclosing cluster
+		if !withinContent(s.Nodes[0], content) {
+			return
+		}
+		links := s.Find("a[href]")
+		hrefs := map[string]bool{}
+		linkText := 0
+		links.Each(func(j int, a *goquery.Selection) {
+			hrefs[a.AttrOr("href", "")] = true
+			linkText += textLength(a)
+		})
+		if len(hrefs) < minLinks {
+			return
+		}
+		total := textLength(s)
+		if total == 0 || float64(linkText)/float64(total) < minLinkDensity || linkText/links.Length() > maxAverageTitle {
+			return
+		}
+
+		thumbnails := s.Find("a img, a picture").Length()
+		if thumbnails < 2 && !trailsContent(s, content) {
+			return
+		}
+
+		// Take the block's heading ("Related articles") with it
+		if heading := s.Prev(); heading.Is("h2, h3, h4, h5, h6, p") && textLength(heading) < 60 && heading.Find("a").Length() == 0 {
+			heading.Remove()
+		}
+		s.Remove()
+	})
+}
+
+// withinContent reports whether node is still attached under content
+func withinContent(node *html.Node, content *goquery.Selection) bool {
+	for ; node != nil; node = node.Parent {
+		if content.IsNodes(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultEdgePhrases mark newsletter, follow, bio and related-content
+// blocks at the edges of an article
+var defaultEdgePhrases = []string{
+	"sign up", "newsletter", "subscribe", "follow us", "share this", "about the author",
+	"related articles", "related stories", "read more", "more from", "you may also like",
+	"recommended for you",
+}
+
+// trimBoilerplateEdges removes leading and trailing blocks that look like
+// boilerplate: short and either mostly links or containing an edge
+// phrase, or empty of both text and media. Trimming stops at the first
+// real block from each end, and at least one block is always kept.
+// Single wrappers are descended into so the blocks are the article's
+// paragraphs
+func trimBoilerplateEdges(content *goquery.Selection, extraPhrases []string) {
+	const (
+		maxEdgeText    = 300
+		maxPhraseText  = 120
+		minLinkDensity = 0.5
+	)
+
+	phrases := append(append([]string{}, defaultEdgePhrases...), extraPhrases...)
+	isBoilerplate := func(s *goquery.Selection) bool {
+		if s.Is("h1, h2, h3, h4, h5, h6") || s.Find(codeSelector).Length() > 0 {
+			return false
+		}
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" {
+			// Media blocks are content; empty leftovers are not
+			return s.Find("img, picture, video, audio, iframe, svg, table").Length() == 0 && !s.Is("img, picture, video, audio, figure, hr")
+		}
+		if len(text) > maxEdgeText {
+			return false
+		}
+		// A lede that merely mentions a phrase is kept unless it is short
+		// or offers a link to act on
+		if len(text) <= maxPhraseText || s.Find("a[href]").Length() > 0 {
+			lower := strings.ToLower(text)
+			for _, phrase := range phrases {
+				if phrase = strings.ToLower(strings.TrimSpace(phrase)); phrase != "" && strings.Contains(lower, phrase) {
+					return true
+				}
+			}
+		}
+		linkText := len(strings.Join(strings.Fields(s.Find("a").Text()), " "))
+		return float64(linkText)/float64(len(text)) >= minLinkDensity
+	}
+
+	root := content
+	for {
+		children := root.Children()
+		if children.Length() != 1 || children.Is("p, h1, h2, h3, h4, h5, h6") {
+			break
+		}
+		root = children
+	}
+
+	blocks := root.Children()
+	first, last := 0, blocks.Length()-1
+	for first < last && isBoilerplate(blocks.Eq(first)) {
+		first++
+	}
+	for last > first && isBoilerplate(blocks.Eq(last)) {
+		last--
+	}
+	blocks.Slice(0, first).Remove()
+	blocks.Slice(last+1, blocks.Length()).Remove()
+}
+
+// trailsContent reports whether no substantial text follows s within
+// content, as for blocks appended after the article body
+func trailsContent(s, content *goquery.Selection) bool {
+	const maxTrailingText = 200
+
+	trailing := 0
+	for node := s.Nodes[0]; node != nil && !content.IsNodes(node); node = node.Parent {
+		for next := node.NextSibling; next != nil; next = next.NextSibling {
+			trailing += len(strings.Join(strings.Fields(goquery.NewDocumentFromNode(next).Text()), " "))
+			if trailing > maxTrailingText {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// collectImages returns the content images in order, deduplicated with
+// absolute URLs, skipping tracking pixels and icon-sized images
+func collectImages(content *goquery.Selection, pageURL *url.URL) []Image {
+	const minGallerySize = 100
+
+	var images []Image
+	seen := map[string]bool{}
+	content.Find("img").Each(func(i int, s *goquery.Selection) {
+		if isTrackingPixel(s) || isTinyImage(s, minGallerySize) {
+			return
+		}
+		src := s.AttrOr("src", "")
+		if !hasUsableSrc(s) {
+			// Lazy images keep the real source in a data attribute
+			for _, attr := range []string{"data-src", "data-lazy-src", "data-original"} {
+				if lazy := s.AttrOr(attr, ""); lazy != "" {
+					src = lazy
+					break
+				}
+			}
+		}
+		imageURL := resolveURL(pageURL, src)
+		if imageURL == "" || strings.HasPrefix(imageURL, "data:") || seen[imageURL] {
+			return
+		}
+		seen[imageURL] = true
+		images = append(images, Image{
+			URL:     imageURL,
+			Alt:     strings.TrimSpace(s.AttrOr("alt", "")),
+			Caption: strings.Join(strings.Fields(s.Closest("figure").Find("figcaption").First().Text()), " "),
+		})
+	})
+	return images
+}
+
+// spacerImageMarkers identify placeholder and tracking image files
+var spacerImageMarkers = []string{"spacer.gif", "pixel.gif", "blank.gif", "transparent.gif", "1x1.", "clear.gif"}
+
+// removeTinyImages drops tracking pixels and spacers: images declared
+// smaller than minSize, tiny data URIs and known placeholder files.
+// Images without declared dimensions are kept
+func removeTinyImages(content *goquery.Selection, minSize int) {
+	if minSize <= 0 {
+		return
+	}
+	content.Find("img").Each(func(i int, s *goquery.Selection) {
+		if isTinyImage(s, float64(minSize)) {
+			s.Remove()
+		}
+	})
+}
+
+// isTinyImage reports whether an image is a spacer or below minSize
+func isTinyImage(img *goquery.Selection, minSize float64) bool {
+	src := strings.ToLower(strings.TrimSpace(img.AttrOr("src", "")))
+	// Data-URI spacers are a few dozen bytes; real inline images are larger.
+	// Lazy images keep a placeholder src until their real source loads
+	_, lazy := img.Attr("data-src")
+	if _, srcset := img.Attr("srcset"); srcset {
+		lazy = true
+	}
+	if strings.HasPrefix(src, "data:") && len(src) < 200 && !lazy {
+		return true
+	}
+	for _, marker := range spacerImageMarkers {
+		if strings.Contains(src, marker) {
+			return true
+		}
+	}
+
+	width := parseDimension(img.AttrOr("width", ""))
+	height := parseDimension(img.AttrOr("height", ""))
+	for _, declaration := range strings.Split(img.AttrOr("style", ""), ";") {
+		property, value, found := strings.Cut(declaration, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(property)) {
+		case "width":
+			width = parseDimension(value)
+		case "height":
+			height = parseDimension(value)
+		}
+	}
+	return (width > 0 && width < minSize) || (height > 0 && height < minSize)
+}
+
+// expandClampedText drops the inline styles of elements whose text is cut
+// off by a line clamp, a max-height with hidden overflow, or a single-line
+// ellipsis, keeping their other declarations
+func expandClampedText(content *goquery.Selection) {
+	content.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		declarations := strings.Split(s.AttrOr("style", ""), ";")
+		properties := make(map[string]string)
+		for _, declaration := range declarations {
+			if property, value, found := strings.Cut(declaration, ":"); found {
+				properties[strings.ToLower(strings.TrimSpace(property))] = strings.ToLower(strings.TrimSpace(value))
+			}
+		}
+
+		clipped := false
+		for _, property := range []string{"overflow", "overflow-y", "overflow-x"} {
+			if value := properties[property]; value == "hidden" || value == "clip" {
+				clipped = true
+			}
+		}
+		_, webkitClamp := properties["-webkit-line-clamp"]
+		_, lineClamp := properties["line-clamp"]
+		if !webkitClamp && !lineClamp &&
+			!(clipped && properties["max-height"] != "") &&
+			!(clipped && properties["text-overflow"] == "ellipsis") {
+			return
+		}
+
+		var kept []string
+		for _, declaration := range declarations {
+			property, value, _ := strings.Cut(declaration, ":")
+			switch strings.ToLower(strings.TrimSpace(property)) {
+			case "-webkit-line-clamp", "line-clamp", "-webkit-box-orient", "max-height",
+				"overflow", "overflow-x", "overflow-y", "text-overflow":
+				continue
+			case "display":
+				if strings.Contains(strings.ToLower(value), "-webkit-box") {
+					continue
+				}
+			case "white-space":
+				if strings.Contains(strings.ToLower(value), "nowrap") {
+					continue
+				}
+			}
+			if declaration = strings.TrimSpace(declaration); declaration != "" {
+				kept = append(kept, declaration)
+			}
+		}
+		if len(kept) == 0 {
+			s.RemoveAttr("style")
+		} else {
+			s.SetAttr("style", strings.Join(kept, "; "))
+		}
+	})
+}
+
+// splitBreakParagraphs splits blocks at runs of two or more <br> into
+// paragraphs. Within a <p> the pieces become sibling paragraphs; other
+// blocks get each inline piece wrapped in a <p>
+func splitBreakParagraphs(content *goquery.Selection) {
+	blank := func(n *html.Node) bool {
+		return n.Type == html.TextNode && strings.TrimSpace(n.Data) == ""
+	}
+	// nextBreak returns the <br> following n across whitespace, if any
+	nextBreak := func(n *html.Node) *html.Node {
+		next := n.NextSibling
+		for next != nil && blank(next) {
+			next = next.NextSibling
+		}
+		if next != nil && next.Type == html.ElementNode && next.Data == "br" {
+			return next
+		}
+		return nil
+	}
+
+	var parents []*html.Node
+	seen := make(map[*html.Node]bool)
+	content.Find("br").Each(func(i int, s *goquery.Selection) {
+		n := s.Nodes[0]
+		if n.Parent == nil || seen[n.Parent] || s.Closest(verbatimSelector).Length() > 0 || nextBreak(n) == nil {
+			return
+		}
+		seen[n.Parent] = true
+		parents = append(parents, n.Parent)
+	})
+
+	for _, parent := range parents {
+		// Cut the children into groups at each run of breaks
+		var groups [][]*html.Node
+		var group []*html.Node
+		for c := parent.FirstChild; c != nil; {
+			if c.Type == html.ElementNode && c.Data == "br" && nextBreak(c) != nil {
+				for c != nil && (blank(c) || (c.Type == html.ElementNode && c.Data == "br")) {
+					next := c.NextSibling
+					parent.RemoveChild(c)
+					c = next
+				}
+				groups = append(groups, group)
+				group = nil
+				continue
+			}
+			next := c.NextSibling
+			parent.RemoveChild(c)
+			group = append(group, c)
+			c = next
+		}
+		groups = append(groups, group)
+
+		newParagraph := func() *html.Node {
+			return &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+		}
+		isParagraph := parent.Data == "p"
+		target := parent
+		for i, nodes := range groups {
+			empty, hasBlock := true, false
+			for _, n := range nodes {
+				if !blank(n) {
+					empty = false
+				}
+				if n.Type == html.ElementNode && blockElements[n.Data] {
+					hasBlock = true
+				}
+			}
+			if empty {
+				continue
+			}
+			switch {
+			case isParagraph:
+				if i > 0 && target.FirstChild != nil {
+					p := newParagraph()
+					parent.Parent.InsertBefore(p, target.NextSibling)
+					target = p
+				}
+				for _, n := range nodes {
+					target.AppendChild(n)
+				}
+			case hasBlock:
+				for _, n := range nodes {
+					parent.AppendChild(n)
+				}
+			default:
+				p := newParagraph()
+				for _, n := range nodes {
+					p.AppendChild(n)
+				}
+				parent.AppendChild(p)
+			}
+		}
+	}
+}
+
+// verbatimSelector matches blocks whose line structure is meaningful
+const verbatimSelector = "pre, code, textarea, .poem, .poetry, .verse, .lyrics"
+
+// mergeBrokenLines rejoins sentences split across <br> tags or paragraphs
+// by PDF-style hard line breaks. Short-line blocks such as poetry and
+// verbatim blocks are left alone
+func mergeBrokenLines(content *goquery.Selection) {
+	const minLineLength = 40
+
+	content.Find("br").Each(func(i int, s *goquery.Selection) {
+		if s.Closest(verbatimSelector).Length() > 0 {
+			return
+		}
+		node := s.Nodes[0]
+		before, after := node.PrevSibling, node.NextSibling
+		if before == nil || after == nil || before.Type != html.TextNode || after.Type != html.TextNode {
+			return
+		}
+		parent := s.Parent()
+		lines := parent.Find("br").Length() + 1
+		if len(strings.TrimSpace(parent.Text()))/lines < minLineLength {
+			return
+		}
+		if continuesSentence(before.Data, after.Data) {
+			before.Data = strings.TrimRight(before.Data, " \t\r\n") + " " + strings.TrimLeft(after.Data, " \t\r\n")
+			node.Parent.RemoveChild(after)
+			s.Remove()
+		}
+	})
+
+	content.Find("p + p").Each(func(i int, s *goquery.Selection) {
+		prev := s.Prev()
+		if s.Closest(verbatimSelector).Length() > 0 || len(strings.TrimSpace(prev.Text())) < minLineLength {
+			return
+		}
+		if continuesSentence(prev.Text(), s.Text()) {
+			prev.AppendHtml(" ")
+			prev.AppendSelection(s.Contents())
+			s.Remove()
+		}
+	})
+}
+
+// bareURLPattern matches http(s) URLs in running text; trailing
+// punctuation is trimmed separately by trimURL
+var bareURLPattern = regexp.MustCompile(`https?://[^\s<>"'\x60]+`)
+
+// linkifyText wraps bare URLs in text nodes with links, leaving existing
+// links and verbatim blocks alone
+func linkifyText(content *goquery.Selection) {
+	var textNodes []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				if strings.Contains(c.Data, "://") {
+					textNodes = append(textNodes, c)
+				}
+			case c.Type == html.ElementNode:
+				switch c.Data {
+				case "a", "pre", "code", "kbd", "samp", "script", "style", "textarea", "button":
+					continue
+				}
+				walk(c)
+			}
+		}
+	}
+	for _, n := range content.Nodes {
+		walk(n)
+	}
+
+	for _, n := range textNodes {
+		matches := bareURLPattern.FindAllStringIndex(n.Data, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		text := n.Data
+		last := 0
+		for _, m := range matches {
+			link := trimURL(text[m[0]:m[1]])
+			// Require a dotted host so fragments like "http://x" stay text
+			if u, err := url.Parse(link); err != nil || !strings.Contains(u.Hostname(), ".") {
+				continue
+			}
+			n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[last:m[0]]}, n)
+			a := &html.Node{
+				Type:     html.ElementNode,
+				Data:     "a",
+				DataAtom: atom.A,
+				Attr:     []html.Attribute{{Key: "href", Val: link}},
+			}
+			a.AppendChild(&html.Node{Type: html.TextNode, Data: link})
+			n.Parent.InsertBefore(a, n)
+			last = m[0] + len(link)
+		}
+		n.Data = text[last:]
+	}
+}
+
+// highlightPhrases wraps case-insensitive matches of the phrases in
+// <mark> elements. Overlapping or adjacent matches merge into one mark, and
+// matches never span elements or reach into code or existing marks
+func highlightPhrases(content *goquery.Selection, phrases []string) {
+	var patterns []*regexp.Regexp
+	for _, phrase := range phrases {
+		if phrase = strings.TrimSpace(phrase); phrase != "" {
+			patterns = append(patterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(phrase)))
+		}
+	}
+	if len(patterns) == 0 {
+		return
+	}
+
+	var textNodes []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				textNodes = append(textNodes, c)
+			case c.Type == html.ElementNode:
+				switch c.Data {
+				case "mark", "pre", "code", "kbd", "samp", "script", "style", "textarea", "svg":
+					continue
+				}
+				walk(c)
+			}
+		}
+	}
+	for _, n := range content.Nodes {
+		walk(n)
+	}
+
+	for _, n := range textNodes {
+		var matches [][]int
+		for _, pattern := range patterns {
+			matches = append(matches, pattern.FindAllStringIndex(n.Data, -1)...)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+		merged := [][]int{matches[0]}
+		for _, m := range matches[1:] {
+			if current := merged[len(merged)-1]; m[0] <= current[1] {
+				current[1] = max(current[1], m[1])
+			} else {
+				merged = append(merged, m)
+			}
+		}
+
+		text := n.Data
+		last := 0
+		for _, m := range merged {
+			n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[last:m[0]]}, n)
+			mark := &html.Node{
+				Type:     html.ElementNode,
+				Data:     "mark",
+				DataAtom: atom.Mark,
+				Attr:     []html.Attribute{{Key: "class", Val: "reader-highlight"}},
+			}
+			mark.AppendChild(&html.Node{Type: html.TextNode, Data: text[m[0]:m[1]]})
+			n.Parent.InsertBefore(mark, n)
+			last = m[1]
+		}
+		n.Data = text[last:]
+	}
+}
+
+// asciiPunctuation maps typographic punctuation to ASCII
+var asciiPunctuation = strings.NewReplacer(
+	"“", `"`, "”", `"`, "„", `"`, "‘", "'", "’", "'", "‚", "'",
+	"—", "--", "–", "-", "…", "...",
+)
+
+// typographicPunctuation maps ASCII dashes and ellipses to typographic
+// forms; quotes depend on context and are handled separately
+var typographicPunctuation = strings.NewReplacer("---", "—", "--", "—", " - ", " – ", "...", "…")
+
+// normalizeTypography rewrites quotes, dashes and ellipses in text nodes
+// outside code, either to ASCII or to typographic forms. Curly quotes open
+// after whitespace, an opening bracket or a dash and close otherwise, with
+// context carried across inline elements
+func normalizeTypography(content *goquery.Selection, ascii bool) {
+	previous := ' '
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.TextNode:
+				if ascii {
+					c.Data = asciiPunctuation.Replace(c.Data)
+					continue
+				}
+				var b strings.Builder
+				for _, r := range typographicPunctuation.Replace(c.Data) {
+					opening := unicode.IsSpace(previous) || strings.ContainsRune("([{<—–-", previous)
+					switch {
+					case r == '"' && opening:
+						r = '“'
+					case r == '"':
+						r = '”'
+					case r == '\'' && opening:
+						r = '‘'
+					case r == '\'':
+						r = '’'
+					}
+					b.WriteRune(r)
+					previous = r
+				}
+				c.Data = b.String()
+			case html.ElementNode:
+				switch c.Data {
+				case "pre", "code", "kbd", "samp", "script", "style", "textarea", "svg":
+					previous = 'x'
+					continue
+				}
+				if blockElements[c.Data] || c.Data == "br" {
+					previous = ' '
+				}
+				walk(c)
+			}
+		}
+	}
+	for _, n := range content.Nodes {
+		walk(n)
+	}
+}
+
+// trimURL strips sentence punctuation and unbalanced closing brackets that
+// follow a URL in prose, keeping balanced ones as in wiki links
+func trimURL(link string) string {
+	for link != "" {
+		last := link[len(link)-1]
+		switch {
+		case strings.IndexByte(".,;:!?*", last) >= 0:
+			link = link[:len(link)-1]
+		case last == ')' && strings.Count(link, "(") < strings.Count(link, ")"):
+			link = link[:len(link)-1]
+		case last == ']' && strings.Count(link, "[") < strings.Count(link, "]"):
+			link = link[:len(link)-1]
+		default:
+			return link
+		}
+	}
+	return link
+}
+
+// continuesSentence reports whether next reads as the continuation of a
+// line ending without sentence-final punctuation
+func continuesSentence(line, next string) bool {
+	line = strings.TrimSpace(line)
+	next = strings.TrimSpace(next)
+	if line == "" || next == "" {
+		return false
+	}
+	last, _ := utf8.DecodeLastRuneInString(line)
+	if strings.ContainsRune(".!?:;\"”’)…", last) {
+		return false
+	}
+	first, _ := utf8.DecodeRuneInString(next)
+	return unicode.IsLower(first)
+}
+
+// annotateLinks marks anchors that point off-site with data-external="true"
+func annotateLinks(content *goquery.Selection, pageURL *url.URL) {
+	siteHost := strings.TrimPrefix(strings.ToLower(pageURL.Hostname()), "www.")
+
+	content.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		link, err := pageURL.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+			return
+		}
+		host := strings.TrimPrefix(strings.ToLower(link.Hostname()), "www.")
+		if host != siteHost {
+			s.SetAttr("data-external", "true")
+		}
+	})
+}
+
+// linkFootnotes rewrites links to anchors within the article, such as
+// footnote references written against the page's full URL, into bare
+// fragments so they jump within the reader page. With tooltips, references
+// to notes also carry the note's text as their title
+func linkFootnotes(content *goquery.Selection, pageURL *url.URL, tooltips bool) {
+	target := func(id string) *goquery.Selection {
+		if id == "" {
+			return nil
+		}
+		quoted := strings.ReplaceAll(id, `"`, `\"`)
+		if found := content.Find(`[id="` + quoted + `"], a[name="` + quoted + `"]`).First(); found.Length() > 0 {
+			return found
+		}
+		return nil
+	}
+
+	content.Find("a[href*='#']").Each(func(i int, s *goquery.Selection) {
+		link, err := pageURL.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil || link.Fragment == "" || link.Host != pageURL.Host || link.Path != pageURL.Path || link.RawQuery != pageURL.RawQuery {
+			return
+		}
+		note := target(link.Fragment)
+		if note == nil {
+			return
+		}
+		s.SetAttr("href", "#"+link.EscapedFragment())
+
+		// Footnote references are superscript markers or declare their role
+		isReference := s.Closest("sup").Length() > 0 || s.AttrOr("role", "") == "doc-noteref"
+		if !tooltips || !isReference || s.AttrOr("title", "") != "" {
+			return
+		}
+		if goquery.NodeName(note) == "a" && note.Parent().Length() > 0 {
+			note = note.Parent()
+		}
+		text := note.Clone()
+		text.Find("a[href^='#'], [role='doc-backlink']").Each(func(i int, backlink *goquery.Selection) {
+			if label := strings.TrimSpace(backlink.Text()); backlink.AttrOr("role", "") == "doc-backlink" || utf8.RuneCountInString(label) <= 2 {
+				backlink.Remove()
+			}
+		})
+		if tooltip := strings.Join(strings.Fields(text.Text()), " "); tooltip != "" {
+			s.SetAttr("title", tooltip)
+			s.AddClass("reader-footnote-ref")
+		}
+	})
+}
+
+// longestMatch returns the matching element with the most text and its length
+func longestMatch(root *goquery.Selection, selector string) (*goquery.Selection, int) {
+	var best *goquery.Selection
+	var bestLength int
+	root.Find(selector).Each(func(i int, s *goquery.Selection) {
+		if length := len(s.Text()); best == nil || length > bestLength {
+			best = s
+			bestLength = length
+		}
+	})
+	return best, bestLength
+}
+
+// threadComment is a single comment in a discussion thread
+type threadComment struct {
+	author string
+	depth  int
+	body   string
+}
+
+// genericCommentSelector matches comments in common nested-comment markup
+const genericCommentSelector = "li.comment, div.comment, article.comment, .comment-item, [itemtype*='schema.org/Comment']"
+
+// extractThread extracts a discussion thread from Hacker News, Reddit or
+// generic nested-comment markup, returning nil when none is found
+func extractThread(doc *goquery.Document) *goquery.Selection {
+	var post string
+	var comments []threadComment
+
+	switch {
+	case doc.Find("tr.athing.comtr").Length() > 0:
+		// Hacker News encodes depth as an indent attribute or spacer width
+		post, _ = doc.Find(".toptext").First().Html()
+		doc.Find("tr.athing.comtr").Each(func(i int, s *goquery.Selection) {
+			depth, err := strconv.Atoi(s.Find("td.ind").AttrOr("indent", ""))
+			if err != nil {
+				depth = int(parseDimension(s.Find("td.ind img").AttrOr("width", ""))) / 40
+			}
+			text := s.Find(".commtext").First()
+			text.Find(".reply").Remove()
+			body, _ := text.Html()
+			comments = append(comments, threadComment{author: s.Find(".hnuser").First().Text(), depth: depth, body: body})
+		})
+	case doc.Find("shreddit-comment").Length() > 0:
+		post, _ = doc.Find("shreddit-post [slot='text-body']").First().Html()
+		doc.Find("shreddit-comment").Each(func(i int, s *goquery.Selection) {
+			depth, _ := strconv.Atoi(s.AttrOr("depth", "0"))
+			body, _ := s.ChildrenFiltered("[slot='comment']").First().Html()
+			comments = append(comments, threadComment{author: s.AttrOr("author", ""), depth: depth, body: body})
+		})
+	case doc.Find(".thing.comment").Length() > 0:
+		post, _ = doc.Find(".thing.link .usertext-body .md").First().Html()
+		doc.Find(".thing.comment").Each(func(i int, s *goquery.Selection) {
+			entry := s.ChildrenFiltered(".entry")
+			body, _ := entry.Find(".usertext-body .md").First().Html()
+			comments = append(comments, threadComment{
+				author: entry.Find("a.author").First().Text(),
+				depth:  s.ParentsFiltered(".thing.comment").Length(),
+				body:   body,
+			})
+		})
+	default:
+		doc.Find(genericCommentSelector).Each(func(i int, s *goquery.Selection) {
+			author := s.Find(".comment-author, .fn, [itemprop='author'], .author, cite").First()
+			text := s.Find(".comment-content, .comment-body, .comment-text, [itemprop='text']").First()
+			if text.Length() == 0 {
+				text = s.Clone()
+				text.Find(genericCommentSelector + ", .comment-meta, .reply, .comment-author, .author").Remove()
+			}
+			body, _ := text.Html()
+			comments = append(comments, threadComment{
+				author: author.Text(),
+				depth:  s.ParentsFiltered(genericCommentSelector).Length(),
+				body:   body,
+			})
+		})
+		// A lone comment block is not a thread
+		if len(comments) < 2 {
+			return nil
+		}
+	}
+
+	if len(comments) == 0 {
+		return nil
+	}
+
+	thread, err := goquery.NewDocumentFromReader(strings.NewReader(renderThread(post, comments)))
+	if err != nil {
+		return nil
+	}
+	thread.Find("script, style, iframe, form, button").Remove()
+	return thread.Find("body")
+}
+
+// renderThread renders the original post and comments as nested blocks so
+// indentation mirrors reply depth
+func renderThread(post string, comments []threadComment) string {
+	var b strings.Builder
+	if strings.TrimSpace(post) != "" {
+		b.WriteString(`<div class="reader-thread-post">` + post + `</div>`)
+	}
+	b.WriteString(`<div class="reader-thread">`)
+
+	open := 0
+	for _, comment := range comments {
+		depth := comment.depth
+		if depth > open {
+			depth = open
+		}
+		// Close comments until we are at this comment's parent
+		for ; open > depth; open-- {
+			b.WriteString(`</div>`)
+		}
+		b.WriteString(`<div class="reader-comment">`)
+		if author := strings.TrimSpace(comment.author); author != "" {
+			b.WriteString(`<div class="reader-comment-author">` + html.EscapeString(author) + `</div>`)
+		}
+		b.WriteString(`<div class="reader-comment-body">` + comment.body + `</div>`)
+		open++
+	}
+	for ; open > 0; open-- {
+		b.WriteString(`</div>`)
+	}
+
+	b.WriteString(`</div>`)
+	return b.String()
+}
+
+// extractRecipe renders the first schema.org Recipe in the JSON-LD as an
+// ingredients list and numbered steps, returning nil when there is none
+func extractRecipe(jsonLD []map[string]interface{}, messages uiMessages) *goquery.Selection {
+	var recipe map[string]interface{}
+	for _, object := range jsonLD {
+		if jsonLDHasType(object, "Recipe") {
+			recipe = object
+			break
+		}
+	}
+	if recipe == nil {
+		return nil
+	}
+
+	ingredients := recipe["recipeIngredient"]
+	if ingredients == nil {
+		ingredients = recipe["ingredients"]
+	}
+	var b strings.Builder
+	b.WriteString(`<div class="reader-recipe">`)
+
+	if description := recipeText(jsonLDString(recipe["description"])); description != "" {
+		b.WriteString(`<p>` + html.EscapeString(description) + `</p>`)
+	}
+
+	var facts []string
+	for _, fact := range []struct{ label, key string }{
+		{messages.Prep, "prepTime"}, {messages.Cook, "cookTime"}, {messages.Total, "totalTime"},
+	} {
+		if duration := formatISODuration(jsonLDString(recipe[fact.key]), messages); duration != "" {
+			facts = append(facts, `<li><strong>`+html.EscapeString(fact.label)+`:</strong> `+html.EscapeString(duration)+`</li>`)
+		}
+	}
+	// Yields are often given as ["4", "4 servings"]; the longest reads best
+	var yield string
+	if values, ok := recipe["recipeYield"].([]interface{}); ok {
+		for _, value := range values {
+			if text := recipeText(jsonLDString(value)); len(text) > len(yield) {
+				yield = text
+			}
+		}
+	} else {
+		yield = recipeText(jsonLDString(recipe["recipeYield"]))
+	}
+	if yield != "" {
+		facts = append(facts, `<li><strong>`+html.EscapeString(messages.Yield)+`:</strong> `+html.EscapeString(yield)+`</li>`)
+	}
+	if len(facts) > 0 {
+		b.WriteString(`<ul class="reader-recipe-facts">` + strings.Join(facts, "") + `</ul>`)
+	}
+
+	items, _ := ingredients.([]interface{})
+	if len(items) > 0 {
+		b.WriteString(`<h2>` + html.EscapeString(messages.Ingredients) + `</h2><ul class="reader-recipe-ingredients">`)
+		for _, item := range items {
+			if text := recipeText(jsonLDString(item, "text", "name")); text != "" {
+				b.WriteString(`<li>` + html.EscapeString(text) + `</li>`)
+			}
+		}
+		b.WriteString(`</ul>`)
+	}
+
+	if steps := renderRecipeSteps(recipe["recipeInstructions"]); steps != "" {
+		b.WriteString(`<h2>` + html.EscapeString(messages.Instructions) + `</h2>` + steps)
+	}
+
+	b.WriteString(`</div>`)
+	if len(items) == 0 && recipe["recipeInstructions"] == nil {
+		return nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		return nil
+	}
+	return doc.Find("body")
+}
+
+// maxReviews caps the reviews listed in the reviews appendix
+const maxReviews = 5
+
+// renderReviews summarises the JSON-LD aggregate rating and reviews as a
+// reviews section, returning "" when the page has neither. Reviews may be
+// nested under the reviewed item or stand alone
+func renderReviews(jsonLD []map[string]interface{}, messages uiMessages) string {
+	var aggregate interface{}
+	var reviews []interface{}
+	for _, object := range jsonLD {
+		if jsonLDHasType(object, "AggregateRating") && aggregate == nil {
+			aggregate = object
+		}
+		if rating, ok := object["aggregateRating"]; ok && aggregate == nil {
+			aggregate = rating
+		}
+		if jsonLDHasType(object, "Review") {
+			reviews = append(reviews, object)
+		}
+		switch nested := object["review"].(type) {
+		case []interface{}:
+			reviews = append(reviews, nested...)
+		case map[string]interface{}:
+			reviews = append(reviews, nested)
+		}
+	}
+
+	var b strings.Builder
+	if rating := formatRating(aggregate, messages); rating != "" {
+		summary := fmt.Sprintf(messages.Rated, rating)
+		count := jsonLDString(aggregate, "ratingCount", "reviewCount")
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			summary += fmt.Sprintf(messages.Ratings, n)
+		}
+		b.WriteString(`<p class="reader-rating">` + html.EscapeString(summary) + `</p>`)
+	}
+
+	var items []string
+	seen := map[string]bool{}
+	for _, review := range reviews {
+		body := recipeText(jsonLDString(review, "reviewBody", "description"))
+		if body == "" || seen[body] {
+			continue
+		}
+		seen[body] = true
+		item := `<li>`
+		if rating := formatRating(jsonLDValue(review, "reviewRating"), messages); rating != "" {
+			item += `<strong>` + html.EscapeString(rating) + `</strong> `
+		}
+		item += `<q>` + html.EscapeString(truncateSentences(body, 300)) + `</q>`
+		if author := recipeText(jsonLDString(jsonLDValue(review, "author"), "name")); author != "" {
+			item += ` <cite>` + html.EscapeString(author) + `</cite>`
+		}
+		items = append(items, item+`</li>`)
+		if len(items) == maxReviews {
+			break
+		}
+	}
+	if len(items) > 0 {
+		b.WriteString(`<ul class="reader-review-list">` + strings.Join(items, "") + `</ul>`)
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return `<section class="reader-reviews"><h2>` + html.EscapeString(messages.Reviews) + `</h2>` + b.String() + `</section>`
+}
+
+// formatRating formats a schema.org Rating as "4.5 out of 5", returning ""
+// when it has no value
+func formatRating(rating interface{}, messages uiMessages) string {
+	value := jsonLDString(rating, "ratingValue")
+	if value == "" {
+		return ""
+	}
+	best := jsonLDString(rating, "bestRating")
+	if best == "" {
+		best = "5"
+	}
+	return fmt.Sprintf(messages.OutOf, value, best)
+}
+
+// extractListing extracts the top-level <article> elements of an index
+// page, rendering them as a list of linked titles with excerpts. It
+// returns nil when fewer than two titled articles are found
+func extractListing(doc *goquery.Document, pageURL *url.URL, previewLength int) (*goquery.Selection, []ListEntry) {
+	var entries []ListEntry
+	doc.Find("article").Each(func(i int, s *goquery.Selection) {
+		if s.ParentsFiltered("article").Length() > 0 {
+			return
+		}
+		heading := s.Find("h1, h2, h3, h4, h5, h6").First()
+		title := strings.Join(strings.Fields(heading.Text()), " ")
+		if title == "" {
+			return
+		}
+		link := heading.Find("a[href]").First()
+		if link.Length() == 0 {
+			link = heading.Closest("a[href]")
+		}
+		if link.Length() == 0 {
+			link = s.Find("a[href]").First()
+		}
+
+		body := s.Clone()
+		sanitizeEntry(body, pageURL)
+		content, err := body.Html()
+		if err != nil {
+			return
+		}
+		body.Find("h1, h2, h3, h4, h5, h6").Remove()
+		excerptHTML, _ := body.Html()
+
+		entries = append(entries, ListEntry{
+			Title:   title,
+			URL:     resolveURL(pageURL, link.AttrOr("href", "")),
+			Excerpt: generatePreview("", extractPlainText(excerptHTML), previewLength),
+			Content: strings.TrimSpace(content),
+		})
+	})
+	if len(entries) < 2 {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="reader-list">`)
+	for _, entry := range entries {
+		title := html.EscapeString(entry.Title)
+		if entry.URL != "" {
+			title = `<a href="` + html.EscapeString(entry.URL) + `">` + title + `</a>`
+		}
+		b.WriteString(`<section class="reader-list-entry"><h2>` + title + `</h2>`)
+		if entry.Excerpt != "" {
+			b.WriteString(`<p>` + html.EscapeString(entry.Excerpt) + `</p>`)
+		}
+		b.WriteString(`</section>`)
+	}
+	b.WriteString(`</div>`)
+
+	listing, err := goquery.NewDocumentFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		return nil, nil
+	}
+	return listing.Find("body"), entries
+}
+
+// sanitizeEntry prepares a listing entry's markup to be returned on its
+// own: active and embedded elements, tracking pixels and inline event
+// handlers are removed, lazy images get their real source and references
+// are made absolute
+func sanitizeEntry(entry *goquery.Selection, pageURL *url.URL) {
+	entry.Find("script, style, noscript, template, iframe, embed, object, form").Remove()
+	entry.Find("img").FilterFunction(func(i int, img *goquery.Selection) bool {
+		return isTrackingPixel(img)
+	}).Remove()
+
+	entry.Find("img").Each(func(i int, img *goquery.Selection) {
+		if !hasUsableSrc(img) {
+			for _, attr := range []string{"data-src", "data-lazy-src", "data-original"} {
+				if lazy := strings.TrimSpace(img.AttrOr(attr, "")); lazy != "" {
+					img.SetAttr("src", lazy)
+					break
+				}
+			}
+		}
+		if srcset := img.AttrOr("data-srcset", ""); srcset != "" && img.AttrOr("srcset", "") == "" {
+			img.SetAttr("srcset", srcset)
+		}
+	})
+
+	entry.Find("*").AddSelection(entry).Each(func(i int, s *goquery.Selection) {
+		node := s.Get(0)
+		attrs := node.Attr[:0]
+		for _, attr := range node.Attr {
+			if strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+				continue
+			}
+			if (attr.Key == "href" || attr.Key == "src") && strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "javascript:") {
+				continue
+			}
+			attrs = append(attrs, attr)
+		}
+		node.Attr = attrs
+	})
+
+	absolutizeURLs(entry, pageURL)
+}
+
+// slideSelectors match the slides of AMP stories and slideshow articles
+const slideSelectors = "amp-story-page, .slideshow-slide, .slide, .swiper-slide, .gallery-slide, [data-slide]"
+
+// slideBlockSelector matches the blocks of a slide kept in reading order
+const slideBlockSelector = "img, video, audio, h1, h2, h3, h4, h5, h6, p, blockquote, ul, ol, figcaption, pre, table"
+
+// extractSlides assembles AMP stories and slideshow articles, whose text
+// is split across slides, into a single article with one section per
+// slide. Slideshows must hold most of the page text, so galleries inside
+// ordinary articles are left to normal extraction. It returns nil when the
+// page is neither
+func extractSlides(doc *goquery.Document) *goquery.Selection {
+	const minSlides = 3
+
+	slides := doc.Find("amp-story amp-story-page")
+	if slides.Length() == 0 {
+		// Slides of one show share a parent; take the largest such group
+		groups := make(map[*html.Node]*goquery.Selection)
+		var parents []*html.Node
+		doc.Find(slideSelectors).Not(".swiper-slide-duplicate").Each(func(i int, s *goquery.Selection) {
+			if s.ParentsFiltered(slideSelectors).Length() > 0 {
+				return
+			}
+			parent := s.Parent().Nodes[0]
+			if groups[parent] == nil {
+				groups[parent] = s
+				parents = append(parents, parent)
+			} else {
+				groups[parent] = groups[parent].AddSelection(s)
+			}
+		})
+		for _, parent := range parents {
+			if groups[parent].Length() > slides.Length() {
+				slides = groups[parent]
+			}
+		}
+		bodyText := len(strings.Join(strings.Fields(doc.Find("body").Text()), " "))
+		slideText := len(strings.Join(strings.Fields(slides.Text()), " "))
+		if slides.Length() < minSlides || slideText*5 < bodyText*3 {
+			return nil
+		}
+	}
+	if slides.Length() == 0 {
+		return nil
+	}
+
+	// Media must be standard HTML before it is copied out
+	convertAMPElements(doc)
+
+	var b strings.Builder
+	b.WriteString(`<div class="reader-slides">`)
+	slides.Each(func(i int, slide *goquery.Selection) {
+		var blocks []string
+		slide.Find(slideBlockSelector).Each(func(j int, block *goquery.Selection) {
+			if block.ParentsFiltered(slideBlockSelector).Length() > 0 {
+				return
+			}
+			if markup, err := goquery.OuterHtml(block); err == nil {
+				blocks = append(blocks, markup)
+			}
+		})
+		// Story text is often set in bare divs and spans
+		if len(blocks) == 0 || slide.Find("h1, h2, h3, h4, h5, h6, p, blockquote, ul, ol").Length() == 0 {
+			clone := slide.Clone()
+			clone.Find("script, style, noscript, template, " + slideBlockSelector).Remove()
+			if text := strings.Join(strings.Fields(clone.Text()), " "); text != "" {
+				blocks = append(blocks, `<p>`+html.EscapeString(text)+`</p>`)
+			}
+		}
+		if len(blocks) > 0 {
+			b.WriteString(`<section class="reader-slide">` + strings.Join(blocks, "") + `</section>`)
+		}
+	})
+	b.WriteString(`</div>`)
+
+	assembled, err := goquery.NewDocumentFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		return nil
+	}
+	return assembled.Find("body")
+}
+
+// renderRecipeSteps renders recipeInstructions, which may be text, a list
+// of strings or HowToStep objects, or HowToSections grouping steps
+func renderRecipeSteps(instructions interface{}) string {
+	var b strings.Builder
+	var steps []string
+	flush := func() {
+		if len(steps) == 0 {
+			return
+		}
+		b.WriteString(`<ol class="reader-recipe-steps">`)
+		for _, step := range steps {
+			b.WriteString(`<li>` + html.EscapeString(step) + `</li>`)
+		}
+		b.WriteString(`</ol>`)
+		steps = nil
+	}
+
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch value := v.(type) {
+		case string:
+			// A single string may hold every step on its own line
+			for _, line := range strings.Split(recipeText(value), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					steps = append(steps, line)
+				}
+			}
+		case []interface{}:
+			for _, item := range value {
+				collect(item)
+			}
+		case map[string]interface{}:
+			if jsonLDHasType(value, "HowToSection") {
+				flush()
+				if name := recipeText(jsonLDString(value["name"])); name != "" {
+					b.WriteString(`<h3>` + html.EscapeString(name) + `</h3>`)
+				}
+				collect(value["itemListElement"])
+				flush()
+				return
+			}
+			if text := recipeText(jsonLDString(value, "text", "name")); text != "" {
+				steps = append(steps, text)
+			}
+		}
+	}
+	collect(instructions)
+	flush()
+	return b.String()
+}
+
+// recipeText decodes the HTML entities that recipe plugins commonly leave
+// in JSON-LD strings
+func recipeText(text string) string {
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// formatISODuration formats an ISO 8601 duration such as "PT1H30M" as
+// "1 hr 30 min", returning "" when it cannot be parsed
+func formatISODuration(value string, messages uiMessages) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if !strings.HasPrefix(value, "P") {
+		return ""
+	}
+	var minutes int
+	number := ""
+	inTime := false
+	for _, r := range value[1:] {
+		switch {
+		case r >= '0' && r <= '9' || r == '.':
+			number += string(r)
+		case r == 'T':
+			inTime = true
+		default:
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return ""
+			}
+			number = ""
+			switch {
+			case r == 'D':
+				minutes += int(n * 24 * 60)
+			case r == 'H' && inTime:
+				minutes += int(n * 60)
+			case r == 'M' && inTime:
+				minutes += int(n)
+			case r == 'S' && inTime:
+				minutes += int(n / 60)
+			default:
+				return ""
+			}
+		}
+	}
+	if minutes <= 0 {
+		return ""
+	}
+
+	var parts []string
+	if hours := minutes / 60; hours > 0 {
+		parts = append(parts, fmt.Sprintf(messages.Hours, hours))
+	}
+	if rest := minutes % 60; rest > 0 {
+		parts = append(parts, fmt.Sprintf(messages.Minutes, rest))
+	}
+	return strings.Join(parts, " ")
+}
+
+// titleSeparators split a site name from a page title, as in "Post | Site"
+var titleSeparators = []string{" | ", " - ", " – ", " — ", " :: ", " · "}
+
+// stripDuplicateTitle removes the first heading of the content when it
+// comes before any paragraph and closely matches the title or the title
+// without its site name
+func stripDuplicateTitle(content *goquery.Selection, title string) {
+	first := content.Find("h1, h2, p").First()
+	if first.Length() == 0 || goquery.NodeName(first) == "p" {
+		return
+	}
+
+	candidates := []string{title}
+	for _, separator := range titleSeparators {
+		candidates = append(candidates, strings.Split(title, separator)...)
+	}
+	heading := first.Text()
+	for _, candidate := range candidates {
+		if similarTitles(heading, candidate) {
+			first.Remove()
+			return
+		}
+	}
+}
+
+// similarTitles reports whether two titles share at least 80% of their
+// words once case, punctuation and spacing are ignored
+func similarTitles(a, b string) bool {
+	wordsA := strings.Split(slugify(a), "-")
+	wordsB := strings.Split(slugify(b), "-")
+	if wordsA[0] == "" || wordsB[0] == "" {
+		return false
+	}
+
+	counts := map[string]int{}
+	for _, word := range wordsA {
+		counts[word]++
+	}
+	shared := 0
+	for _, word := range wordsB {
+		if counts[word] > 0 {
+			counts[word]--
+			shared++
+		}
+	}
+	// Dice coefficient over the word multisets
+	return float64(2*shared)/float64(len(wordsA)+len(wordsB)) >= 0.8
+}
+
+// buildTableOfContents lists the content's headings in document order with
+// the word count of each heading's section. Text before the first heading
+// is not counted
+func buildTableOfContents(content *goquery.Selection) []TOCEntry {
+	toc := []TOCEntry{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if len(toc) > 0 {
+				toc[len(toc)-1].Words += len(strings.Fields(n.Data))
+			}
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "script", "style", "template":
+				return
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				heading := goquery.NewDocumentFromNode(n).Selection
+				toc = append(toc, TOCEntry{
+					ID:    heading.AttrOr("id", ""),
+					Text:  strings.Join(strings.Fields(heading.Text()), " "),
+					Level: int(n.Data[1] - '0'),
+				})
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range content.Nodes {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	return toc
+}
+
+// flattenDeepHeadings turns headings deeper than maxDepth into bold
+// paragraphs, keeping their ids for fragment links. Zero keeps all levels
+func flattenDeepHeadings(content *goquery.Selection, maxDepth int) {
+	if maxDepth <= 0 {
+		return
+	}
+	var deeper []string
+	for level := maxDepth + 1; level <= 6; level++ {
+		deeper = append(deeper, fmt.Sprintf("h%d", level))
+	}
+	if len(deeper) == 0 {
+		return
+	}
+	content.Find(strings.Join(deeper, ", ")).Each(func(i int, s *goquery.Selection) {
+		inner, err := s.Html()
+		if err != nil {
+			return
+		}
+		paragraph := "<p>"
+		if id := s.AttrOr("id", ""); id != "" {
+			paragraph = `<p id="` + html.EscapeString(id) + `">`
+		}
+		s.ReplaceWithHtml(paragraph + "<strong>" + inner + "</strong></p>")
+	})
+}
+
+// assignHeadingIDs keeps existing heading ids for deep links and generates
+// slug ids for headings without one, ensuring ids stay unique
+func assignHeadingIDs(content *goquery.Selection) {
+	used := make(map[string]bool)
+
+	// Reserve ids already present on non-heading elements first
+	content.Find("[id]").Not("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		used[s.AttrOr("id", "")] = true
+	})
+
+	// Existing heading ids win over generated ones
+	headings := content.Find("h1, h2, h3, h4, h5, h6")
+	headings.Each(func(i int, s *goquery.Selection) {
+		id := strings.TrimSpace(s.AttrOr("id", ""))
+		if id == "" {
+			return
+		}
+		if used[id] {
+			s.RemoveAttr("id")
+			return
+		}
+		used[id] = true
+	})
+
+	headings.Each(func(i int, s *goquery.Selection) {
+		if s.AttrOr("id", "") != "" {
+			return
+		}
+		base := slugify(s.Text())
+		if base == "" {
+			base = "section"
+		}
+		id := base
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[id] = true
+		s.SetAttr("id", id)
+	})
+}
+
+// slugify converts text into a lowercase, hyphen-separated anchor id
+func slugify(text string) string {
+	var b strings.Builder
+	lastHyphen := true
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			lastHyphen = false
+		} else if !lastHyphen {
+			b.WriteRune('-')
+			lastHyphen = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// blockElements start a new paragraph in plain-text output
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"blockquote": true, "pre": true, "figure": true, "figcaption": true,
+	"table": true, "tr": true, "hr": true, "header": true, "footer": true,
+}
+
+// canonicalHTML re-serializes content HTML deterministically: attributes
+// are sorted, comments dropped, whitespace runs collapsed to one space and
+// whitespace between blocks removed. Preformatted text is left as is
+func canonicalHTML(contentHTML string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(contentHTML), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return contentHTML
+	}
+
+	isBlock := func(n *html.Node) bool {
+		return n == nil || (n.Type == html.ElementNode && blockElements[n.Data])
+	}
+	// Drop comments first, joining the text around them
+	var uncomment func(n *html.Node)
+	uncomment = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch {
+			case c.Type == html.CommentNode:
+				n.RemoveChild(c)
+			case c.Type == html.TextNode && c.PrevSibling != nil && c.PrevSibling.Type == html.TextNode:
+				c.PrevSibling.Data += c.Data
+				n.RemoveChild(c)
+			case c.Type == html.ElementNode:
+				uncomment(c)
+			}
+			c = next
+		}
+	}
+
+	var normalize func(n *html.Node)
+	normalize = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			sort.SliceStable(n.Attr, func(i, j int) bool { return n.Attr[i].Key < n.Attr[j].Key })
+			switch n.Data {
+			case "pre", "textarea", "script", "style":
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch c.Type {
+			case html.TextNode:
+				if strings.TrimSpace(c.Data) == "" && isBlock(c.PrevSibling) && isBlock(c.NextSibling) {
+					n.RemoveChild(c)
+				} else {
+					c.Data = collapseWhitespace(c.Data)
+				}
+			case html.ElementNode:
+				normalize(c)
+			}
+			c = next
+		}
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		if n.Type == html.CommentNode {
+			continue
+		}
+		if n.Type == html.TextNode {
+			if strings.TrimSpace(n.Data) == "" {
+				continue
+			}
+			n.Data = collapseWhitespace(n.Data)
+		}
+		uncomment(n)
+		normalize(n)
+		if err := html.Render(&b, n); err != nil {
+			return contentHTML
+		}
+	}
+	return b.String()
+}
+
+// collapseWhitespace replaces each run of whitespace with a single space,
+// keeping a leading or trailing space
+func collapseWhitespace(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// extractPlainText converts content HTML to plain text, separating
+// paragraphs with blank lines
+func extractPlainText(contentHTML string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(contentHTML), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return ""
+	}
+
+	var paragraphs []string
+	var current strings.Builder
+	flush := func() {
+		if text := strings.Join(strings.Fields(current.String()), " "); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+		current.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			current.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "script", "style", "template":
+				return
+			case "br":
+				current.WriteString(" ")
+				return
+			case "pre":
+				// Code keeps its indentation and blank lines
+				flush()
+				if text := strings.Trim(nodeText(n), "\n"); strings.TrimSpace(text) != "" {
+					paragraphs = append(paragraphs, text)
+				}
+				return
+			}
+		}
+
+		block := n.Type == html.ElementNode && blockElements[n.Data]
+		if block {
+			flush()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			flush()
+		}
+	}
+
+	for _, n := range nodes {
+		walk(n)
+	}
+	flush()
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// segmentSentences wraps each sentence of the content in spans carrying a
+// data-segment-id and returns the sentences in reading order. A sentence
+// crossing inline markup is split into several spans sharing its id.
+// Preformatted blocks are not segmented
+func segmentSentences(content *goquery.Selection) []Segment {
+	var segments []Segment
+	var run []*html.Node
+
+	flush := func() {
+		nodes := run
+		run = nil
+		var joined strings.Builder
+		for _, n := range nodes {
+			joined.WriteString(n.Data)
+		}
+		text := joined.String()
+
+		// Find sentence spans as byte ranges over the run's text
+		var starts, ends []int
+		open := false
+		wordStart := -1
+		endWord := func(end int) {
+			if !open {
+				starts = append(starts, wordStart)
+				open = true
+			}
+			if endsSentence(text[wordStart:end]) {
+				ends = append(ends, end)
+				open = false
+			}
+			wordStart = -1
+		}
+		for i, r := range text {
+			switch {
+			case unicode.IsSpace(r) && wordStart >= 0:
+				endWord(i)
+			case !unicode.IsSpace(r) && wordStart < 0:
+				wordStart = i
+			}
+		}
+		if wordStart >= 0 {
+			endWord(len(text))
+		}
+		if open {
+			ends = append(ends, len(text))
+		}
+		if len(starts) == 0 {
+			return
+		}
+
+		first := len(segments)
+		for i := range starts {
+			segments = append(segments, Segment{
+				ID:   "s" + strconv.Itoa(len(segments)+1),
+				Text: strings.Join(strings.Fields(text[starts[i]:ends[i]]), " "),
+			})
+		}
+
+		// Split each text node at sentence boundaries and wrap the pieces
+		offset := 0
+		for _, n := range nodes {
+			nodeStart, nodeEnd := offset, offset+len(n.Data)
+			offset = nodeEnd
+			pos := nodeStart
+			for i := range starts {
+				if starts[i] >= nodeEnd || ends[i] <= nodeStart {
+					continue
+				}
+				from, to := max(starts[i], nodeStart), min(ends[i], nodeEnd)
+				if from > pos {
+					n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[pos:from]}, n)
+				}
+				span := &html.Node{
+					Type:     html.ElementNode,
+					Data:     "span",
+					DataAtom: atom.Span,
+					Attr:     []html.Attribute{{Key: "data-segment-id", Val: segments[first+i].ID}},
+				}
+				span.AppendChild(&html.Node{Type: html.TextNode, Data: text[from:to]})
+				n.Parent.InsertBefore(span, n)
+				pos = to
+			}
+			if pos == nodeStart {
+				continue
+			}
+			if pos < nodeEnd {
+				n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[pos:nodeEnd]}, n)
+			}
+			n.Parent.RemoveChild(n)
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			run = append(run, n)
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "script", "style", "template", "pre":
+				flush()
+				return
+			}
+		}
+
+		block := n.Type == html.ElementNode && blockElements[n.Data]
+		if block {
+			flush()
+		}
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			walk(c)
+			c = next
+		}
+		if block {
+			flush()
+		}
+	}
+
+	for _, n := range content.Nodes {
+		walk(n)
+	}
+	flush()
+	return segments
+}
+
+// contentHash returns a SHA-256 dedup key of the text, normalized so that
+// copies differing only in case, punctuation or whitespace hash the same
+func contentHash(plainText string) string {
+	var normalized strings.Builder
+	for _, word := range strings.Fields(strings.ToLower(plainText)) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, word)
+		if word == "" {
+			continue
+		}
+		if normalized.Len() > 0 {
+			normalized.WriteByte(' ')
+		}
+		normalized.WriteString(word)
+	}
+	sum := sha256.Sum256([]byte(normalized.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// abbreviations are words ending in a period that do not end a sentence
+var abbreviations = map[string]bool{
+	"mr.": true, "mrs.": true, "ms.": true, "dr.": true, "prof.": true,
+	"sr.": true, "jr.": true, "st.": true, "vs.": true, "etc.": true,
+	"e.g.": true, "i.e.": true, "inc.": true, "ltd.": true, "co.": true,
+	"no.": true, "fig.": true, "approx.": true, "u.s.": true, "u.k.": true,
+	"jan.": true, "feb.": true, "mar.": true, "apr.": true, "jun.": true,
+	"jul.": true, "aug.": true, "sep.": true, "sept.": true, "oct.": true,
+	"nov.": true, "dec.": true,
+}
+
+// splitSentences splits text into sentences, skipping abbreviations and
+// single-letter initials
+func splitSentences(text string) []string {
+	var sentences []string
+	var current []string
+	for _, word := range strings.Fields(text) {
+		current = append(current, word)
+		if !endsSentence(word) {
+			continue
+		}
+		sentences = append(sentences, strings.Join(current, " "))
+		current = nil
+	}
+	if len(current) > 0 {
+		sentences = append(sentences, strings.Join(current, " "))
+	}
+	return sentences
+}
+
+// endsSentence reports whether a word closes a sentence
+func endsSentence(word string) bool {
+	trimmed := strings.TrimRight(word, "\"'”’)]")
+	if trimmed == "" {
+		return false
+	}
+	switch trimmed[len(trimmed)-1] {
+	case '!', '?':
+		return true
+	case '.':
+		lower := strings.ToLower(trimmed)
+		if abbreviations[lower] {
+			return false
+		}
+		// Initials such as "J." in "J. R. R. Tolkien"
+		if utf8.RuneCountInString(trimmed) == 2 && unicode.IsUpper([]rune(trimmed)[0]) {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// truncateSentences shortens text to whole sentences within maxLength,
+// falling back to a word boundary when the first sentence is too long
+func truncateSentences(text string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+
+	var kept []string
+	length := 0
+	for _, sentence := range splitSentences(text) {
+		n := utf8.RuneCountInString(sentence)
+		if length > 0 {
+			n++
+		}
+		if length+n > maxLength {
+			break
+		}
+		kept = append(kept, sentence)
+		length += n
+	}
+
+	if len(kept) > 0 {
+		result := strings.Join(kept, " ")
+		if strings.HasSuffix(result, ".") {
+			return strings.TrimSuffix(result, ".") + "…"
+		}
+		return result + " …"
+	}
+
+	var words []string
+	length = 0
+	for _, word := range strings.Fields(text) {
+		n := utf8.RuneCountInString(word) + 1
+		if length+n > maxLength {
+			break
+		}
+		words = append(words, word)
+		length += n
+	}
+	return strings.TrimRight(strings.Join(words, " "), ",;:") + "…"
+}
+
+// generatePreview builds a teaser, preferring the meta description when it
+// carries enough text and otherwise deriving it from the article body
+func generatePreview(description, plainText string, maxLength int) string {
+	description = strings.Join(strings.Fields(description), " ")
+	if utf8.RuneCountInString(description)*5 >= maxLength*3 {
+		return truncateSentences(description, maxLength)
+	}
+
+	// Skip leading headings and captions, which lack sentence punctuation
+	paragraphs := strings.Split(plainText, "\n\n")
+	for len(paragraphs) > 1 {
+		words := strings.Fields(paragraphs[0])
+		if len(words) >= 8 || (len(words) > 0 && endsSentence(words[len(words)-1])) {
+			break
+		}
+		paragraphs = paragraphs[1:]
+	}
+
+	body := strings.Join(strings.Fields(strings.Join(paragraphs, " ")), " ")
+	if body == "" {
+		return description
+	}
+	return truncateSentences(body, maxLength)
+}
+
+// pageData is the data exposed to page templates. Content, Styles and the
+// pre-formatted byline fields are trusted; everything else is escaped
+type pageData struct {
+	*Article
+	Content         template.HTML
+	Author          string
+	Byline          template.HTML
+	Dateline        template.HTML
+	Updateline      template.HTML
+	ShowSection     bool
+	ShowBreadcrumbs bool
+	Truncated       bool
+	TitleLevel      int
+	Messages        uiMessages
+	Colors          map[string]string
+	Styles          template.CSS
+	InlineStyles    bool
+	StyleNonce      string
+	StylesheetURL   string
+	GoogleFonts     bool
+}
+
+// stylesheetData is the data exposed to the stylesheet template. The root
+// and body selectors are replaced by the container for scoped fragments
+type stylesheetData struct {
+	Colors       map[string]string
+	ExtraCSS     string
+	RootSelector string
+	BodySelector string
+	SansFont     string
+	MonoFont     string
+}
+
+// stylesheetTemplate renders the reader CSS. It is a text template because
+// every value is a trusted theme constant and the CSS may be served alone
+var stylesheetTemplate = texttemplate.Must(texttemplate.New("stylesheet").Parse(`        {{.RootSelector}} {
+            --base: {{.Colors.base}}; --mantle: {{.Colors.mantle}}; --crust: {{.Colors.crust}}; --text: {{.Colors.text}};
+            --subtext1: {{.Colors.subtext1}}; --subtext0: {{.Colors.subtext0}}; --surface0: {{.Colors.surface0}}; --surface1: {{.Colors.surface1}};
+            --surface2: {{.Colors.surface2}}; --blue: {{.Colors.blue}}; --lavender: {{.Colors.lavender}}; --sapphire: {{.Colors.sapphire}};
+            --sky: {{.Colors.sky}}; --green: {{.Colors.green}}; --mauve: {{.Colors.mauve}}; --overlay1: {{.Colors.overlay1}};
+            --yellow: {{.Colors.yellow}}; --peach: {{.Colors.peach}};
+        }
+        
+        {{.BodySelector}} {
+            background-color: rgb(var(--base)); color: rgb(var(--text));
+            font-family: '{{.SansFont}}', sans-serif; font-weight: 300;
+            line-height: 1.7; margin: 0; padding: 2rem 1rem;
+        }
+        
+        .reader-container { max-width: 65ch; margin: 0 auto; }
+        
+        .reader-header {
+            border-bottom: 2px solid rgb(var(--surface0));
+            padding-bottom: 2rem; margin-bottom: 3rem;
+        }
+        
+        .reader-breadcrumbs {
+            font-size: 0.8rem; color: rgb(var(--overlay1)); margin-bottom: 0.75rem;
+        }
+        
+        .reader-breadcrumbs a { color: rgb(var(--subtext0)); text-decoration: none; }
+        
+        .reader-title {
+            font-size: 2.5rem; font-weight: 700; color: rgb(var(--blue));
+            margin-bottom: 1rem; line-height: 1.2;
+        }
+        
+        .reader-meta {
+            color: rgb(var(--subtext1)); font-size: 0.9rem;
+            display: flex; align-items: center; gap: 1rem; flex-wrap: wrap;
+        }
+        
+        .reader-source {
+            color: rgb(var(--sapphire)); text-decoration: none;
+            padding: 0.25rem 0.75rem; background-color: rgb(var(--surface0));
+            border-radius: 0.5rem; font-size: 0.8rem;
+        }
+        
+        .reader-source:hover { background-color: rgb(var(--surface1)); }
+        
+        .reader-meta .author a { color: inherit; text-decoration-color: rgb(var(--surface2)); }
+        
+        .reader-section {
+            color: rgb(var(--mauve)); border: 1px solid rgb(var(--surface1));
+            border-radius: 999px; padding: 0.1rem 0.6rem; font-size: 0.75rem;
+            text-transform: uppercase; letter-spacing: 0.05em;
+        }
+        
+        .reader-content h1, .reader-content h2, .reader-content h3,
+        .reader-content h4, .reader-content h5, .reader-content h6 {
+            color: rgb(var(--lavender)); font-weight: 600;
+            margin-top: 2.5rem; margin-bottom: 1rem; line-height: 1.3;
+        }
+        
+        .reader-content p { margin-bottom: 1.5rem; text-align: justify; }
+        
+        .reader-content a {
+            color: rgb(var(--blue)); text-decoration: underline;
+            text-decoration-color: rgb(var(--surface2)); text-underline-offset: 3px;
+        }
+        
+        .reader-content a:hover {
+            color: rgb(var(--sky)); text-decoration-color: rgb(var(--sky));
+        }
+        
+        .reader-content code {
+            font-family: '{{.MonoFont}}', monospace; background-color: rgb(var(--surface0));
+            color: rgb(var(--green)); padding: 0.2rem 0.4rem;
+            border-radius: 0.25rem; font-size: 0.9em;
+        }
+        
+        .reader-content pre {
+            font-family: '{{.MonoFont}}', monospace; background-color: rgb(var(--crust));
+            color: rgb(var(--text)); padding: 1.5rem; border-radius: 0.5rem;
+            overflow-x: auto; margin: 2rem 0; border: 1px solid rgb(var(--surface0));
+        }
+        
+        .reader-recipe-facts {
+            list-style: none; padding: 1rem 1.5rem; display: flex; flex-wrap: wrap; gap: 0.5rem 2rem;
+            background-color: rgb(var(--mantle)); border-radius: 0.5rem;
+        }
+        
+        .reader-recipe-steps li { margin-bottom: 0.75rem; }
+        
+        .reader-reviews {
+            margin-top: 3rem; padding-top: 1.5rem; border-top: 1px solid rgb(var(--surface0));
+        }
+        
+        .reader-rating { font-weight: 600; color: rgb(var(--yellow)); }
+        
+        .reader-review-list { list-style: none; padding: 0; }
+        
+        .reader-review-list li { margin-bottom: 1rem; }
+        
+        .reader-review-list cite { display: block; color: rgb(var(--subtext0)); font-size: 0.9em; }
+        
+        .reader-list-entry {
+            padding-bottom: 1.5rem; margin-bottom: 1.5rem;
+            border-bottom: 1px solid rgb(var(--surface0));
+        }
+        
+        .reader-list-entry h2 { margin-top: 0; }
+        
+        .reader-slide + .reader-slide {
+            border-top: 1px solid rgb(var(--surface0)); margin-top: 2rem; padding-top: 2rem;
+        }
+        
+        .reader-cta { text-align: center; margin: 3rem 0 1rem; }
+        
+        .reader-cta-button {
+            display: inline-block; padding: 0.75rem 1.75rem; border-radius: 0.5rem;
+            background-color: rgb(var(--surface0)); color: rgb(var(--blue));
+            border: 1px solid rgb(var(--blue)); font-weight: 600; text-decoration: none;
+        }
+        
+        .reader-cta-button:hover { background-color: rgb(var(--blue)); color: rgb(var(--base)); }
+        
+        .reader-freshness {
+            color: rgb(var(--overlay1)); font-size: 0.85rem;
+        }
+        
+        .reader-code-block { position: relative; margin: 2rem 0; }
+        
+        .reader-code-block pre { margin: 0; }
+        
+        .reader-code-label {
+            position: absolute; top: 0; right: 0; padding: 0.15rem 0.6rem;
+            font-family: '{{.MonoFont}}', monospace; font-size: 0.75rem;
+            color: rgb(var(--subtext0)); background-color: rgb(var(--surface0));
+            border-radius: 0 0.5rem 0 0.5rem;
+        }
+        
+        .reader-content hr {
+            border: none; border-top: 1px solid rgb(var(--surface1));
+            width: 40%; margin: 3rem auto;
+        }
+        
+        .reader-comment { margin: 1.25rem 0; }
+        
+        .reader-comment .reader-comment {
+            margin-left: 1.25rem; padding-left: 1rem;
+            border-left: 2px solid rgb(var(--surface1));
+        }
+        
+        .reader-comment-author {
+            color: rgb(var(--mauve)); font-weight: 600; font-size: 0.9rem;
+        }
+        
+        .reader-thread-post {
+            border-bottom: 1px solid rgb(var(--surface0)); margin-bottom: 2rem;
+        }
+        
+        .reader-content sup, .reader-content sub {
+            font-size: 0.75em; line-height: 0; position: relative; vertical-align: baseline;
+        }
+        
+        .reader-content sup { top: -0.5em; }
+        
+        .reader-content sub { bottom: -0.25em; }
+        
+        .reader-content .reader-footnote-ref { cursor: help; }
+        
+        .reader-content kbd {
+            font-family: '{{.MonoFont}}', monospace; font-size: 0.85em;
+            background-color: rgb(var(--surface0)); color: rgb(var(--text));
+            border: 1px solid rgb(var(--surface2)); border-bottom-width: 3px;
+            border-radius: 0.3rem; padding: 0.1rem 0.4rem; white-space: nowrap;
+        }
+        
+        .reader-content samp {
+            font-family: '{{.MonoFont}}', monospace; font-size: 0.9em; color: rgb(var(--peach));
+        }
+        
+        .reader-content var { font-style: italic; color: rgb(var(--lavender)); }
+        
+        .reader-content abbr[title] {
+            text-decoration: underline dotted rgb(var(--subtext0)); text-underline-offset: 0.2em; cursor: help;
+        }
+        
+        .reader-content mark {
+            background-color: rgb(var(--yellow)); color: rgb(var(--base));
+            padding: 0 0.2em; border-radius: 0.2rem;
+        }
+        
+        .reader-content blockquote {
+            border-left: 4px solid rgb(var(--mauve)); background-color: rgb(var(--mantle));
+            padding: 1.5rem; margin: 2rem 0; border-radius: 0 0.5rem 0.5rem 0;
+            font-style: italic; color: rgb(var(--subtext1));
+        }
+        
+        .reader-content .reader-pullquote {
+            margin: 2.5rem 0; padding: 1.5rem 1rem; text-align: center;
+            font-size: 1.4rem; font-weight: 600; line-height: 1.4; color: rgb(var(--lavender));
+            border-top: 2px solid rgb(var(--surface1)); border-bottom: 2px solid rgb(var(--surface1));
+        }
+        
+        .reader-content .reader-quote-attribution {
+            margin-top: 1rem; font-size: 0.9rem; font-style: normal;
+            color: rgb(var(--subtext0));
+        }
+        
+        .reader-container[dir="rtl"] .reader-content p { text-align: right; }
+        
+        .reader-container[dir="rtl"] .reader-content blockquote {
+            border-left: none; border-right: 4px solid rgb(var(--mauve));
+            border-radius: 0.5rem 0 0 0.5rem;
+        }
+        
+        @media (max-width: 768px) {
+            .reader-container { padding: 1rem 0.75rem; }
+            .reader-title { font-size: 2rem; }
+        }
+        {{.ExtraCSS}}
+`))
+
+// generateStylesheet renders the reader CSS for the configured features
+func generateStylesheet(config *Config) (string, error) {
+	var out strings.Builder
+	data := stylesheetData{
+		Colors:       themeColors(flavourByName(config.Flavor)),
+		ExtraCSS:     optionalCSS(config),
+		RootSelector: ":root",
+		BodySelector: "body",
+		SansFont:     "Ysabeau Infant",
+		MonoFont:     "Victor Mono",
+	}
+	if config.Fonts == "embedded" {
+		data.SansFont, data.MonoFont = embeddedSansFamily, embeddedMonoFamily
+	}
+	if config.Fragment {
+		data.RootSelector = ".reader-container"
+		data.BodySelector = ".reader-container"
+	}
+	if err := stylesheetTemplate.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render stylesheet: %v", err)
+	}
+	return out.String(), nil
+}
+
+// emailFormatter renders content HTML as plain text for email, collecting
+// link targets as numbered references
+type emailFormatter struct {
+	width int
+	base  *url.URL
+	links []string
+}
+
+// generateEmailText renders the article as plain text: a header block,
+// paragraphs hard-wrapped at width, "- " bullets and [n] link references
+// listed at the end
+func generateEmailText(article *Article, width int, messages uiMessages) string {
+	if width <= 0 {
+		width = 72
+	}
+	base, _ := url.Parse(article.URL)
+	f := &emailFormatter{width: width, base: base}
+
+	header := []string{article.Title, strings.Repeat("=", min(utf8.RuneCountInString(article.Title), width))}
+	if len(article.Authors) > 0 {
+		header = append(header, fmt.Sprintf(messages.By, messages.joinNames(article.Authors)))
+	}
+	if article.PublishDate != "" {
+		header = append(header, fmt.Sprintf(messages.Published, article.PublishDate))
+	}
+	header = append(header, fmt.Sprintf(messages.Source, article.URL))
+	blocks := []string{strings.Join(header, "\n")}
+
+	nodes, err := html.ParseFragment(strings.NewReader(article.Content), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err == nil {
+		root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+		for _, n := range nodes {
+			root.AppendChild(n)
+		}
+		blocks = append(blocks, f.blocks(root, "")...)
+	}
+
+	if len(f.links) > 0 {
+		references := []string{messages.Links + ":"}
+		for i, link := range f.links {
+			references = append(references, fmt.Sprintf("[%d] %s", i+1, link))
+		}
+		blocks = append(blocks, strings.Join(references, "\n"))
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// blocks renders the children of n as text blocks, each line prefixed
+func (f *emailFormatter) blocks(n *html.Node, prefix string) []string {
+	var blocks []string
+	var inline strings.Builder
+	flush := func() {
+		if text := f.wrap(inline.String(), prefix); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		// Table sections only group rows, so they are walked like blocks
+		section := c.Type == html.ElementNode && (c.Data == "thead" || c.Data == "tbody" || c.Data == "tfoot")
+		if c.Type != html.ElementNode || !blockElements[c.Data] && !section {
+			f.inline(c, &inline)
+			continue
+		}
+		flush()
+		switch c.Data {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			var heading strings.Builder
+			f.inline(c, &heading)
+			if text := f.wrap(heading.String(), prefix); text != "" {
+				underline := "-"
+				if c.Data == "h1" || c.Data == "h2" {
+					underline = "="
+				}
+				lastLine := text[strings.LastIndex(text, "\n")+1:]
+				width := utf8.RuneCountInString(lastLine) - len(prefix)
+				blocks = append(blocks, text+"\n"+prefix+strings.Repeat(underline, max(width, 1)))
+			}
+		case "ul", "ol":
+			if list := f.list(c, prefix); list != "" {
+				blocks = append(blocks, list)
+			}
+		case "blockquote":
+			blocks = append(blocks, f.blocks(c, prefix+"> ")...)
+		case "pre":
+			var lines []string
+			for _, line := range strings.Split(strings.TrimRight(nodeText(c), "\n"), "\n") {
+				lines = append(lines, strings.TrimRight(prefix+"    "+line, " "))
+			}
+			blocks = append(blocks, strings.Join(lines, "\n"))
+		case "hr":
+			blocks = append(blocks, prefix+strings.Repeat("-", 10))
+		case "tr":
+			var cells []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				var text strings.Builder
+				f.inline(cell, &text)
+				if value := strings.Join(strings.Fields(text.String()), " "); value != "" {
+					cells = append(cells, value)
+				}
+			}
+			if text := f.wrap(strings.Join(cells, " | "), prefix); text != "" {
+				blocks = append(blocks, text)
+			}
+		default:
+			blocks = append(blocks, f.blocks(c, prefix)...)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// list renders list items with "- " or "1. " markers, indenting wrapped
+// lines and nested content under the item text
+func (f *emailFormatter) list(n *html.Node, prefix string) string {
+	var items []string
+	number := 0
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		number++
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+		}
+		indent := prefix + strings.Repeat(" ", len(marker))
+		item := strings.Join(f.blocks(li, indent), "\n")
+		if item == "" {
+			continue
+		}
+		items = append(items, prefix+marker+strings.TrimPrefix(item, indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+// inline appends the text of an inline node, marking links with [n]
+// references, images with their alt text and line breaks with newlines
+func (f *emailFormatter) inline(n *html.Node, b *strings.Builder) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.Data {
+	case "script", "style", "template":
+		return
+	case "br":
+		b.WriteString("\n")
+		return
+	case "img":
+		for _, attr := range n.Attr {
+			if attr.Key == "alt" && strings.TrimSpace(attr.Val) != "" {
+				b.WriteString(" [Image: " + strings.TrimSpace(attr.Val) + "] ")
+			}
+		}
+		return
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		f.inline(c, b)
+	}
+	if n.Data == "a" {
+		for _, attr := range n.Attr {
+			if attr.Key != "href" || f.base == nil {
+				continue
+			}
+			link, err := f.base.Parse(strings.TrimSpace(attr.Val))
+			if err != nil || (link.Scheme != "http" && link.Scheme != "https" && link.Scheme != "mailto") {
+				continue
+			}
+			f.links = append(f.links, link.String())
+			fmt.Fprintf(b, " [%d]", len(f.links))
+		}
+	}
+}
+
+// wrap hard-wraps text at the formatter width, keeping explicit line
+// breaks and starting every line with prefix
+func (f *emailFormatter) wrap(text, prefix string) string {
+	limit := max(f.width-utf8.RuneCountInString(prefix), 20)
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		var line strings.Builder
+		length := 0
+		for _, word := range strings.Fields(paragraph) {
+			n := utf8.RuneCountInString(word)
+			if length > 0 && length+1+n > limit {
+				lines = append(lines, prefix+line.String())
+				line.Reset()
+				length = 0
+			}
+			if length > 0 {
+				line.WriteByte(' ')
+				length++
+			}
+			line.WriteString(word)
+			length += n
+		}
+		if length > 0 {
+			lines = append(lines, prefix+line.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nodeText returns the text content of a node and its descendants
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+// semanticData is the data exposed to the semantic HTML5 template
+type semanticData struct {
+	*Article
+	Content   template.HTML
+	Published string
+	Modified  string
+	Messages  uiMessages
+}
+
+// semanticTemplate renders an unstyled HTML5 document with schema.org
+// Article microdata
+var semanticTemplate = template.Must(template.New("semantic").Funcs(template.FuncMap{"localize": localizeHTML}).Parse(`<!DOCTYPE html>
+<html{{with .Language}} lang="{{.}}"{{end}}{{if eq .Direction "rtl"}} dir="rtl"{{end}}>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="canonical" href="{{.URL}}">{{with .Description}}
+<meta name="description" content="{{.}}">{{end}}
+</head>
+<body>
+<article itemscope itemtype="https://schema.org/Article">
+<header>
+<h1 itemprop="headline">{{.Title}}</h1>{{range .Authors}}
+<p itemprop="author" itemscope itemtype="https://schema.org/Person">{{localize $.Messages.By "span" "name" .}}</p>{{end}}{{with .Published}}
+<p>{{localize $.Messages.Published "time" "datePublished" .}}</p>{{end}}{{with .Modified}}
+<p>{{localize $.Messages.Updated "time" "dateModified" .}}</p>{{end}}{{with .Section}}
+<p itemprop="articleSection">{{.}}</p>{{end}}{{with .Description}}
+<p itemprop="description">{{.}}</p>{{end}}
+</header>
+<div itemprop="articleBody">
+{{.Content}}
+</div>
+<footer>
+<p>{{localize .Messages.Source "a" "url" .URL}}</p>
+</footer>
+</article>
+</body>
+</html>
+`))
+
+// localizeHTML fills a message format such as "By %s" with value wrapped
+// in a microdata element: <span itemprop> for names, <time> for dates and
+// <a> for URLs
+func localizeHTML(format, element, prop, value string) template.HTML {
+	value = html.EscapeString(value)
+	var markup string
+	switch element {
+	case "time":
+		markup = `<time itemprop="` + prop + `" datetime="` + value + `">` + value + `</time>`
+	case "a":
+		markup = `<a itemprop="` + prop + `" href="` + value + `">` + value + `</a>`
+	default:
+		markup = `<` + element + ` itemprop="` + prop + `">` + value + `</` + element + `>`
+	}
+	return template.HTML(fmt.Sprintf(html.EscapeString(format), markup))
+}
+
+// generateSemanticDocument renders the article as a semantic HTML5
+// document for archiving and re-processing. Dates use normalized ISO 8601
+func generateSemanticDocument(article *Article, messages uiMessages) (string, error) {
+	data := semanticData{
+		Article:   article,
+		Content:   template.HTML(semanticContent(article.Content)),
+		Published: normalizeDate(article.PublishDate),
+		Modified:  normalizeDate(article.ModifiedDate),
+		Messages:  messages,
+	}
+	var out strings.Builder
+	if err := semanticTemplate.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render HTML5 document: %v", err)
+	}
+	return out.String(), nil
+}
+
+// semanticContent strips presentational attributes and elements from
+// content and wraps standalone images in <figure>
+func semanticContent(contentHTML string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		return contentHTML
+	}
+	body := doc.Find("body")
+	body.Find("[style], [class], [align], [bgcolor], [border]").Each(func(i int, s *goquery.Selection) {
+		for _, attr := range []string{"style", "class", "align", "bgcolor", "border"} {
+			s.RemoveAttr(attr)
+		}
+	})
+	body.Find("font, center").Each(func(i int, s *goquery.Selection) {
+		s.Contents().Unwrap()
+	})
+
+	body.Find("img").Each(func(i int, s *goquery.Selection) {
+		if s.Closest("figure, a, table").Length() > 0 {
+			return
+		}
+		// An image alone in its paragraph becomes a figure in its place
+		target := s
+		if parent := s.Parent(); parent.Is("p, div") && strings.TrimSpace(parent.Text()) == "" && parent.Children().Length() == 1 {
+			target = parent
+		}
+		markup, err := goquery.OuterHtml(s)
+		if err != nil {
+			return
 		}
-	}
-
-	if contentSelection == nil || maxLength < 100 {
-		contentSelection = doc.Find("body")
-		contentSelection.Find("header, footer, nav, aside, .sidebar, .navigation, .menu").Remove()
-	}
+		target.ReplaceWithHtml("<figure>" + markup + "</figure>")
+	})
 
-	contentHTML, err := contentSelection.Html()
+	semanticHTML, err := body.Html()
 	if err != nil {
-		return ""
+		return contentHTML
 	}
-	return contentHTML
+	return semanticHTML
 }
 
-// generateReadablePage creates readable HTML
-func generateReadablePage(title, content, sourceURL, author, publishDate, description string) string {
-	mocha := catppuccin.Mocha
-
-	return fmt.Sprintf(`<!DOCTYPE html>
-<html lang="en">
+// defaultPageTemplate renders the standard Catppuccin reader page
+var defaultPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="{{with .Language}}{{.}}{{else}}en{{end}}">
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>%s - Go Reader</title>
+    <title>{{.Title}} - {{.Messages.Brand}}</title>
     
-    <link rel="preconnect" href="https://fonts.googleapis.com">
+    {{if .GoogleFonts}}<link rel="preconnect" href="https://fonts.googleapis.com">
     <link rel="preconnect" href="https://fonts.gstatic.com" crossorigin>
-    <link href="https://fonts.googleapis.com/css2?family=Victor+Mono:ital,wght@0,100..700;1,100..700&family=Ysabeau+Infant:ital,wght@0,1..1000;1,1..1000&display=swap" rel="stylesheet">
+    <link href="https://fonts.googleapis.com/css2?family=Victor+Mono:ital,wght@0,100..700;1,100..700&family=Ysabeau+Infant:ital,wght@0,1..1000;1,1..1000&display=swap" rel="stylesheet">{{end}}
     
-    <style>
-        :root {
-            --base: %s; --mantle: %s; --crust: %s; --text: %s;
-            --subtext1: %s; --subtext0: %s; --surface0: %s; --surface1: %s;
-            --surface2: %s; --blue: %s; --lavender: %s; --sapphire: %s;
-            --sky: %s; --green: %s; --mauve: %s;
-        }
-        
-        body {
-            background-color: rgb(var(--base)); color: rgb(var(--text));
-            font-family: 'Ysabeau Infant', sans-serif; font-weight: 300;
-            line-height: 1.7; margin: 0; padding: 2rem 1rem;
-        }
-        
-        .reader-container { max-width: 65ch; margin: 0 auto; }
-        
-        .reader-header {
-            border-bottom: 2px solid rgb(var(--surface0));
-            padding-bottom: 2rem; margin-bottom: 3rem;
-        }
-        
-        .reader-title {
-            font-size: 2.5rem; font-weight: 700; color: rgb(var(--blue));
-            margin-bottom: 1rem; line-height: 1.2;
-        }
-        
-        .reader-meta {
-            color: rgb(var(--subtext1)); font-size: 0.9rem;
-            display: flex; align-items: center; gap: 1rem; flex-wrap: wrap;
-        }
-        
-        .reader-source {
-            color: rgb(var(--sapphire)); text-decoration: none;
-            padding: 0.25rem 0.75rem; background-color: rgb(var(--surface0));
-            border-radius: 0.5rem; font-size: 0.8rem;
-        }
-        
-        .reader-source:hover { background-color: rgb(var(--surface1)); }
-        
-        .reader-content h1, .reader-content h2, .reader-content h3,
-        .reader-content h4, .reader-content h5, .reader-content h6 {
-            color: rgb(var(--lavender)); font-weight: 600;
-            margin-top: 2.5rem; margin-bottom: 1rem; line-height: 1.3;
-        }
-        
-        .reader-content p { margin-bottom: 1.5rem; text-align: justify; }
-        
-        .reader-content a {
-            color: rgb(var(--blue)); text-decoration: underline;
-            text-decoration-color: rgb(var(--surface2)); text-underline-offset: 3px;
-        }
-        
-        .reader-content a:hover {
-            color: rgb(var(--sky)); text-decoration-color: rgb(var(--sky));
-        }
-        
-        .reader-content code {
-            font-family: 'Victor Mono', monospace; background-color: rgb(var(--surface0));
-            color: rgb(var(--green)); padding: 0.2rem 0.4rem;
-            border-radius: 0.25rem; font-size: 0.9em;
-        }
-        
-        .reader-content pre {
-            font-family: 'Victor Mono', monospace; background-color: rgb(var(--crust));
-            color: rgb(var(--text)); padding: 1.5rem; border-radius: 0.5rem;
-            overflow-x: auto; margin: 2rem 0; border: 1px solid rgb(var(--surface0));
-        }
-        
-        .reader-content blockquote {
-            border-left: 4px solid rgb(var(--mauve)); background-color: rgb(var(--mantle));
-            padding: 1.5rem; margin: 2rem 0; border-radius: 0 0.5rem 0.5rem 0;
-            font-style: italic; color: rgb(var(--subtext1));
-        }
-        
-        @media (max-width: 768px) {
-            .reader-container { padding: 1rem 0.75rem; }
-            .reader-title { font-size: 2rem; }
-        }
-    </style>
+    {{if .InlineStyles}}<style{{with .StyleNonce}} nonce="{{.}}"{{end}}>
+{{.Styles}}    </style>{{else if .StylesheetURL}}<link rel="stylesheet" href="{{.StylesheetURL}}">{{end}}{{range .Stylesheets}}
+    <link rel="stylesheet" href="{{.}}">{{end}}
 </head>
 <body>
-    <div class="reader-container">
-        <header class="reader-header">
-            <h1 class="reader-title">%s</h1>
+{{template "container" .}}</body>
+</html>
+{{- define "container"}}    <div class="reader-container"{{if eq .Direction "rtl"}} dir="rtl"{{end}}>
+        <header class="reader-header">{{if and .ShowBreadcrumbs .Breadcrumbs}}
+            <nav class="reader-breadcrumbs" aria-label="Breadcrumb">{{range $i, $crumb := .Breadcrumbs}}{{if $i}}<span aria-hidden="true"> › </span>{{end}}{{if $crumb.URL}}<a href="{{$crumb.URL}}">{{$crumb.Name}}</a>{{else}}<span>{{$crumb.Name}}</span>{{end}}{{end}}</nav>{{end}}
+            {{if eq .TitleLevel 2}}<h2 class="reader-title">{{.Title}}</h2>{{else if eq .TitleLevel 3}}<h3 class="reader-title">{{.Title}}</h3>{{else}}<h1 class="reader-title">{{.Title}}</h1>{{end}}
             <div class="reader-meta">
-                <span>Go Reader</span>
-                %s %s
-                <a href="%s" class="reader-source" target="_blank" rel="noopener noreferrer">
-                    View Original
+                <span>{{.Messages.Brand}}</span>
+                {{.Byline}} {{.Dateline}}{{with .Updateline}}
+                {{.}}{{end}}{{with .Freshness}}
+                <span class="reader-freshness">{{.}}</span>{{end}}{{if and .ShowSection .Section}}
+                <span class="reader-section">{{.Section}}</span>{{end}}
+                <a href="{{.URL}}" class="reader-source" target="_blank" rel="noopener noreferrer">
+                    {{.Messages.ViewOriginal}}
                 </a>
             </div>
         </header>
         
         <main class="reader-content">
-            %s
+            {{.Content}}{{if .Truncated}}
+            <div class="reader-cta">
+                <a href="{{.URL}}" class="reader-cta-button" target="_blank" rel="noopener noreferrer">{{.Messages.ReadFull}}</a>
+            </div>{{end}}
         </main>
     </div>
-</body>
-</html>`,
-		html.EscapeString(title),
-		colorToRGB(mocha.Base()), colorToRGB(mocha.Mantle()), colorToRGB(mocha.Crust()),
-		colorToRGB(mocha.Text()), colorToRGB(mocha.Subtext1()), colorToRGB(mocha.Subtext0()),
-		colorToRGB(mocha.Surface0()), colorToRGB(mocha.Surface1()), colorToRGB(mocha.Surface2()),
-		colorToRGB(mocha.Blue()), colorToRGB(mocha.Lavender()), colorToRGB(mocha.Sapphire()),
-		colorToRGB(mocha.Sky()), colorToRGB(mocha.Green()), colorToRGB(mocha.Mauve()),
-		html.EscapeString(title),
-		formatAuthor(author), formatPublishDate(publishDate),
-		html.EscapeString(sourceURL),
-		content,
-	)
+{{end}}
+{{- define "fragment"}}{{range .Stylesheets}}<link rel="stylesheet" href="{{.}}">
+{{end}}{{if .InlineStyles}}<style{{with .StyleNonce}} nonce="{{.}}"{{end}}>
+{{.Styles}}</style>
+{{end}}{{template "container" .}}{{end}}`))
+
+// generateReadablePage creates readable HTML, using the caller's template
+// when one is configured, and reports whether maxWords cut the content
+func generateReadablePage(article *Article, config *Config) (string, bool, error) {
+	tmpl := defaultPageTemplate
+	if config.Template != "" {
+		custom, err := template.New("custom").Parse(config.Template)
+		if err != nil {
+			return "", false, &ReaderError{Code: "invalid_template", Message: fmt.Sprintf("invalid template: %v", err)}
+		}
+		tmpl = custom
+	}
+
+	styles, err := generateStylesheet(config)
+	if err != nil {
+		return "", false, err
+	}
+
+	contentHTML, truncated := truncateWords(article.Content, config.MaxWords)
+	messages := messagesFor(config.UILanguage)
+
+	data := pageData{
+		Article:         article,
+		Content:         template.HTML(labelCodeBlocks(contentHTML)),
+		Truncated:       truncated,
+		Author:          messages.joinNames(article.Authors),
+		Byline:          template.HTML(formatAuthor(article.Authors, article.AuthorURL, messages)),
+		Dateline:        template.HTML(formatPublishDate(article.PublishDate)),
+		Updateline:      template.HTML(formatModifiedDate(article.PublishDate, article.ModifiedDate, messages)),
+		ShowSection:     config.ShowSection,
+		ShowBreadcrumbs: config.ShowBreadcrumbs,
+		TitleLevel:      config.TitleLevel,
+		Messages:        messages,
+		Colors:          themeColors(flavourByName(config.Flavor)),
+		Styles:          template.CSS(styles),
+		InlineStyles:    config.StyleMode != "external" && config.StyleMode != "original" && !(config.Fragment && config.FragmentStyles == "none"),
+		StyleNonce:      config.StyleNonce,
+		StylesheetURL:   config.StylesheetURL,
+		GoogleFonts:     config.Fonts != "embedded",
+	}
+
+	// Fragments omit the document wrapper so output can be embedded in a page
+	name := "page"
+	if config.Fragment && config.Template == "" {
+		name = "fragment"
+	}
+
+	// Custom templates are executed as a whole; only the built-in one
+	// defines the named page and fragment templates
+	var out strings.Builder
+	if config.Template != "" {
+		err = tmpl.Execute(&out, data)
+	} else {
+		err = tmpl.ExecuteTemplate(&out, name, data)
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to render page: %v", err)
+	}
+	return out.String(), truncated, nil
+}
+
+// languageNames maps common code language identifiers to display names;
+// others are shown as declared
+var languageNames = map[string]string{
+	"bash": "Bash", "c": "C", "cpp": "C++", "c++": "C++", "csharp": "C#", "cs": "C#",
+	"css": "CSS", "diff": "Diff", "dockerfile": "Dockerfile", "go": "Go", "golang": "Go",
+	"graphql": "GraphQL", "html": "HTML", "java": "Java", "javascript": "JavaScript", "js": "JavaScript",
+	"json": "JSON", "jsx": "JSX", "kotlin": "Kotlin", "kt": "Kotlin", "lua": "Lua", "makefile": "Makefile",
+	"markdown": "Markdown", "md": "Markdown", "objectivec": "Objective-C", "php": "PHP", "powershell": "PowerShell",
+	"ps1": "PowerShell", "python": "Python", "py": "Python", "rb": "Ruby", "ruby": "Ruby", "rs": "Rust",
+	"rust": "Rust", "scala": "Scala", "scss": "SCSS", "sh": "Shell", "shell": "Shell", "sql": "SQL",
+	"swift": "Swift", "toml": "TOML", "ts": "TypeScript", "tsx": "TSX", "typescript": "TypeScript",
+	"xml": "XML", "yaml": "YAML", "yml": "YAML", "zsh": "Zsh",
+}
+
+// codeLanguage returns the language declared on a code block by class
+// (language-x, lang-x, highlight-source-x) or data attribute
+func codeLanguage(s *goquery.Selection) string {
+	for _, attr := range []string{"data-lang", "data-language"} {
+		if lang := strings.TrimSpace(s.AttrOr(attr, "")); lang != "" {
+			return lang
+		}
+	}
+	for _, class := range strings.Fields(s.AttrOr("class", "")) {
+		for _, prefix := range []string{"language-", "lang-", "highlight-source-"} {
+			if lang := strings.TrimPrefix(class, prefix); lang != class && lang != "" {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// labelCodeBlocks wraps code blocks that declare a language so the
+// language name can be shown in the block's corner
+func labelCodeBlocks(contentHTML string) string {
+	if !strings.Contains(contentHTML, "<pre") {
+		return contentHTML
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		return contentHTML
+	}
+
+	labelled := false
+	doc.Find("pre").Each(func(i int, s *goquery.Selection) {
+		lang := codeLanguage(s)
+		if lang == "" {
+			lang = codeLanguage(s.ChildrenFiltered("code").First())
+		}
+		if lang == "" || strings.EqualFold(lang, "text") || strings.EqualFold(lang, "plaintext") {
+			return
+		}
+		name, ok := languageNames[strings.ToLower(lang)]
+		if !ok {
+			name = lang
+		}
+		s.WrapHtml(`<div class="reader-code-block"></div>`)
+		s.BeforeHtml(`<span class="reader-code-label">` + html.EscapeString(name) + `</span>`)
+		labelled = true
+	})
+	if !labelled {
+		return contentHTML
+	}
+
+	labelledHTML, err := doc.Find("body").Html()
+	if err != nil {
+		return contentHTML
+	}
+	return labelledHTML
+}
+
+// flavourByName returns the Catppuccin flavour with the given name,
+// defaulting to Mocha
+func flavourByName(name string) catppuccin.Flavour {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "latte":
+		return catppuccin.Latte
+	case "frappe", "frappé":
+		return catppuccin.Frappe
+	case "macchiato":
+		return catppuccin.Macchiato
+	default:
+		return catppuccin.Mocha
+	}
+}
+
+// Bundled fonts for offline output: Open Sans Light, Semibold and Bold
+// (Apache 2.0) and Source Code Pro Medium (OFL 1.1), licences alongside in
+// fonts/. The theme's own fonts are not bundled, so the stylesheet names
+// these families instead when they are embedded
+const (
+	embeddedSansFamily = "Open Sans"
+	embeddedMonoFamily = "Source Code Pro"
+)
+
+var (
+	//go:embed fonts/open-sans-300.woff2
+	embeddedSansLight []byte
+	//go:embed fonts/open-sans-600.woff2
+	embeddedSansSemibold []byte
+	//go:embed fonts/open-sans-700.woff2
+	embeddedSansBold []byte
+	//go:embed fonts/source-code-pro-500.woff2
+	embeddedMonoFont []byte
+)
+
+// embeddedFontFaces returns @font-face rules serving the bundled fonts.
+// Each face declares its real weight; the sans family covers the 600 and
+// 700 weights of titles and headings so they are not synthesized
+func embeddedFontFaces() string {
+	var faces []string
+	for _, face := range []struct {
+		family string
+		weight int
+		data   []byte
+	}{
+		{embeddedSansFamily, 300, embeddedSansLight},
+		{embeddedSansFamily, 600, embeddedSansSemibold},
+		{embeddedSansFamily, 700, embeddedSansBold},
+		{embeddedMonoFamily, 500, embeddedMonoFont},
+	} {
+		faces = append(faces, fmt.Sprintf(`@font-face {
+            font-family: '%s'; font-weight: %d; font-display: swap;
+            src: url(data:font/woff2;base64,%s) format('woff2');
+        }`, face.family, face.weight, base64.StdEncoding.EncodeToString(face.data)))
+	}
+	return strings.Join(faces, "\n        ")
+}
+
+// optionalCSS returns the stylesheet rules for opt-in rendering features
+func optionalCSS(config *Config) string {
+	var rules []string
+	// Dimming only helps on dark backgrounds, so Latte is left untouched
+	if config.DimImages && flavourByName(config.Flavor) != catppuccin.Latte {
+		rules = append(rules, fmt.Sprintf(`.reader-content img {
+            filter: brightness(%.2f); transition: filter 0.2s ease-in-out;
+        }
+        .reader-content img:hover { filter: none; }`, config.ImageBrightness))
+	}
+	if config.ExternalLinkMarker {
+		rules = append(rules, `.reader-content a[data-external="true"]::after {
+            content: "\2197"; font-size: 0.75em; margin-left: 0.15em;
+            color: rgb(var(--subtext0)); text-decoration: none; display: inline-block;
+        }`)
+	}
+	if config.ExpandAbbreviations {
+		rules = append(rules, `@media (hover: none) {
+            .reader-content abbr.reader-abbr-first[title]::after {
+                content: " (" attr(title) ")"; color: rgb(var(--subtext0));
+            }
+        }`)
+	}
+	if config.LabelSponsored {
+		rules = append(rules, `.reader-content .reader-sponsored {
+            display: block; border-left: 3px solid rgb(var(--peach)); padding-left: 0.75rem;
+        }
+        .reader-content .reader-sponsored::before {
+            content: `+strconv.Quote(messagesFor(config.UILanguage).Sponsored)+`; display: block; font-size: 0.75rem; font-weight: 600;
+            letter-spacing: 0.05em; text-transform: uppercase; color: rgb(var(--peach));
+        }`)
+	}
+	if config.Fonts == "embedded" {
+		rules = append(rules, embeddedFontFaces())
+	}
+	if config.SameOriginImages || len(config.AllowedImageTypes) > 0 {
+		rules = append(rules, `.reader-content .reader-blocked-image {
+            display: block; margin: 1.5rem 0; padding: 1rem; font-size: 0.9rem;
+            border: 1px dashed rgb(var(--surface2)); border-radius: 8px; color: rgb(var(--subtext0));
+        }`)
+	}
+	return strings.Join(rules, "\n        ")
+}
+
+// rssItem is a single RSS 2.0 item with content and Dublin Core extensions
+type rssItem struct {
+	XMLName        xml.Name   `xml:"item"`
+	ContentNS      string     `xml:"xmlns:content,attr"`
+	DCNS           string     `xml:"xmlns:dc,attr"`
+	Title          string     `xml:"title"`
+	Link           string     `xml:"link"`
+	GUID           rssGUID    `xml:"guid"`
+	Creators       []string   `xml:"dc:creator"`
+	PubDate        string     `xml:"pubDate,omitempty"`
+	Description    string     `xml:"description,omitempty"`
+	ContentEncoded rssContent `xml:"content:encoded"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssContent struct {
+	Value string `xml:",cdata"`
+}
+
+// atomEntry is a single Atom 1.0 entry
+type atomEntry struct {
+	XMLName   xml.Name     `xml:"entry"`
+	NS        string       `xml:"xmlns,attr"`
+	Title     string       `xml:"title"`
+	Link      atomLink     `xml:"link"`
+	ID        string       `xml:"id"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published,omitempty"`
+	Authors   []atomAuthor `xml:"author"`
+	Summary   string       `xml:"summary,omitempty"`
+	Content   atomContent  `xml:"content"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// generateRSSItem renders the article as an RSS <item> element
+func generateRSSItem(article *Article) (string, error) {
+	item := rssItem{
+		ContentNS:      "http://purl.org/rss/1.0/modules/content/",
+		DCNS:           "http://purl.org/dc/elements/1.1/",
+		Title:          article.Title,
+		Link:           article.URL,
+		GUID:           rssGUID{IsPermaLink: "true", Value: article.URL},
+		Creators:       article.Authors,
+		Description:    article.Description,
+		ContentEncoded: rssContent{Value: article.Content},
+	}
+	if t, ok := parseDate(article.PublishDate); ok {
+		item.PubDate = t.Format(time.RFC1123Z)
+	}
+
+	out, err := xml.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render RSS item: %v", err)
+	}
+	return string(out), nil
+}
+
+// generateAtomEntry renders the article as an Atom <entry> element
+func generateAtomEntry(article *Article) (string, error) {
+	entry := atomEntry{
+		NS:      "http://www.w3.org/2005/Atom",
+		Title:   article.Title,
+		Link:    atomLink{Href: article.URL, Rel: "alternate"},
+		ID:      article.URL,
+		Summary: article.Description,
+		Content: atomContent{Type: "html", Value: article.Content},
+	}
+	// Atom requires <updated>; fall back to the processing time
+	entry.Published = normalizeDate(article.PublishDate)
+	entry.Updated = normalizeDate(article.ModifiedDate)
+	if entry.Updated == "" {
+		entry.Updated = entry.Published
+	}
+	if entry.Updated == "" {
+		entry.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	for _, author := range article.Authors {
+		entry.Authors = append(entry.Authors, atomAuthor{Name: author})
+	}
+
+	out, err := xml.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render Atom entry: %v", err)
+	}
+	return string(out), nil
+}
+
+// jsonFeedItem is a single JSON Feed 1.1 item
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Author        *jsonFeedAuthor  `json:"author,omitempty"` // JSON Feed 1.0
+	Tags          []string         `json:"tags,omitempty"`
+	Language      string           `json:"language,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// generateJSONFeedItem builds the article as a JSON Feed item
+func generateJSONFeedItem(article *Article) *jsonFeedItem {
+	item := &jsonFeedItem{
+		ID:            article.URL,
+		URL:           article.URL,
+		Title:         article.Title,
+		ContentHTML:   article.Content,
+		ContentText:   extractPlainText(article.Content),
+		Summary:       article.Description,
+		DatePublished: normalizeDate(article.PublishDate),
+		DateModified:  normalizeDate(article.ModifiedDate),
+		Tags:          article.Keywords,
+		Language:      article.Language,
+	}
+	for _, author := range article.Authors {
+		item.Authors = append(item.Authors, jsonFeedAuthor{Name: author})
+	}
+	if len(article.Authors) > 0 {
+		item.Author = &jsonFeedAuthor{Name: joinNatural(article.Authors)}
+	}
+	return item
+}
+
+// uiMessages are the reader's own strings in one language. By, Updated,
+// Published, Source, Rated and OutOf are format strings taking names,
+// dates, URLs or ratings; Ratings, Hours and Minutes take a count
+type uiMessages struct {
+	Brand        string
+	ViewOriginal string
+	ReadFull     string
+	By           string
+	Updated      string
+	Published    string
+	Source       string
+	Links        string
+	Untitled     string
+	And          string
+	// Today, JustNow, In and Ago phrase relative times; Units holds the
+	// singular and plural of minute, hour, day, month and year
+	Today         string
+	JustNow       string
+	In            string
+	Ago           string
+	Units         [5][2]string
+	Ingredients   string
+	Instructions  string
+	Prep          string
+	Cook          string
+	Total         string
+	Yield         string
+	Hours         string
+	Minutes       string
+	Reviews       string
+	Rated         string
+	Ratings       string
+	OutOf         string
+	Sponsored     string
+	ExternalImage string
+	Image         string
 }
 
-// formatAuthor formats author for display
-func formatAuthor(author string) string {
-	if author == "" {
+// uiLocales holds the translations of uiMessages by language
+var uiLocales = map[string]uiMessages{
+	"en": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "View Original",
+		ReadFull:      "Read the full article",
+		By:            "By %s",
+		Updated:       "Updated %s",
+		Published:     "Published %s",
+		Source:        "Source: %s",
+		Links:         "Links",
+		Untitled:      "Untitled",
+		And:           "and",
+		Today:         "today",
+		JustNow:       "just now",
+		In:            "in %s",
+		Ago:           "%s ago",
+		Units:         [5][2]string{{"minute", "minutes"}, {"hour", "hours"}, {"day", "days"}, {"month", "months"}, {"year", "years"}},
+		Ingredients:   "Ingredients",
+		Instructions:  "Instructions",
+		Prep:          "Prep",
+		Cook:          "Cook",
+		Total:         "Total",
+		Yield:         "Yield",
+		Hours:         "%d hr",
+		Minutes:       "%d min",
+		Reviews:       "Reviews",
+		Rated:         "Rated %s",
+		Ratings:       " from %d ratings",
+		OutOf:         "%s out of %s",
+		Sponsored:     "Sponsored",
+		ExternalImage: "External image",
+		Image:         "Image",
+	},
+	"de": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "Original ansehen",
+		ReadFull:      "Ganzen Artikel lesen",
+		By:            "Von %s",
+		Updated:       "Aktualisiert %s",
+		Published:     "Veröffentlicht %s",
+		Source:        "Quelle: %s",
+		Links:         "Links",
+		Untitled:      "Ohne Titel",
+		And:           "und",
+		Today:         "heute",
+		JustNow:       "gerade eben",
+		In:            "in %s",
+		Ago:           "vor %s",
+		Units:         [5][2]string{{"Minute", "Minuten"}, {"Stunde", "Stunden"}, {"Tag", "Tagen"}, {"Monat", "Monaten"}, {"Jahr", "Jahren"}},
+		Ingredients:   "Zutaten",
+		Instructions:  "Zubereitung",
+		Prep:          "Vorbereitung",
+		Cook:          "Kochzeit",
+		Total:         "Gesamt",
+		Yield:         "Portionen",
+		Hours:         "%d Std.",
+		Minutes:       "%d Min.",
+		Reviews:       "Bewertungen",
+		Rated:         "Bewertet mit %s",
+		Ratings:       " aus %d Bewertungen",
+		OutOf:         "%s von %s",
+		Sponsored:     "Gesponsert",
+		ExternalImage: "Externes Bild",
+		Image:         "Bild",
+	},
+	"es": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "Ver original",
+		ReadFull:      "Leer el artículo completo",
+		By:            "Por %s",
+		Updated:       "Actualizado %s",
+		Published:     "Publicado %s",
+		Source:        "Fuente: %s",
+		Links:         "Enlaces",
+		Untitled:      "Sin título",
+		And:           "y",
+		Today:         "hoy",
+		JustNow:       "justo ahora",
+		In:            "dentro de %s",
+		Ago:           "hace %s",
+		Units:         [5][2]string{{"minuto", "minutos"}, {"hora", "horas"}, {"día", "días"}, {"mes", "meses"}, {"año", "años"}},
+		Ingredients:   "Ingredientes",
+		Instructions:  "Instrucciones",
+		Prep:          "Preparación",
+		Cook:          "Cocción",
+		Total:         "Total",
+		Yield:         "Raciones",
+		Hours:         "%d h",
+		Minutes:       "%d min",
+		Reviews:       "Reseñas",
+		Rated:         "Valoración: %s",
+		Ratings:       " de %d valoraciones",
+		OutOf:         "%s de %s",
+		Sponsored:     "Patrocinado",
+		ExternalImage: "Imagen externa",
+		Image:         "Imagen",
+	},
+	"fr": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "Voir l'original",
+		ReadFull:      "Lire l'article complet",
+		By:            "Par %s",
+		Updated:       "Mis à jour %s",
+		Published:     "Publié %s",
+		Source:        "Source : %s",
+		Links:         "Liens",
+		Untitled:      "Sans titre",
+		And:           "et",
+		Today:         "aujourd'hui",
+		JustNow:       "à l'instant",
+		In:            "dans %s",
+		Ago:           "il y a %s",
+		Units:         [5][2]string{{"minute", "minutes"}, {"heure", "heures"}, {"jour", "jours"}, {"mois", "mois"}, {"an", "ans"}},
+		Ingredients:   "Ingrédients",
+		Instructions:  "Instructions",
+		Prep:          "Préparation",
+		Cook:          "Cuisson",
+		Total:         "Total",
+		Yield:         "Portions",
+		Hours:         "%d h",
+		Minutes:       "%d min",
+		Reviews:       "Avis",
+		Rated:         "Noté %s",
+		Ratings:       " (%d avis)",
+		OutOf:         "%s sur %s",
+		Sponsored:     "Sponsorisé",
+		ExternalImage: "Image externe",
+		Image:         "Image",
+	},
+	"it": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "Vedi originale",
+		ReadFull:      "Leggi l'articolo completo",
+		By:            "Di %s",
+		Updated:       "Aggiornato %s",
+		Published:     "Pubblicato %s",
+		Source:        "Fonte: %s",
+		Links:         "Link",
+		Untitled:      "Senza titolo",
+		And:           "e",
+		Today:         "oggi",
+		JustNow:       "proprio ora",
+		In:            "tra %s",
+		Ago:           "%s fa",
+		Units:         [5][2]string{{"minuto", "minuti"}, {"ora", "ore"}, {"giorno", "giorni"}, {"mese", "mesi"}, {"anno", "anni"}},
+		Ingredients:   "Ingredienti",
+		Instructions:  "Procedimento",
+		Prep:          "Preparazione",
+		Cook:          "Cottura",
+		Total:         "Totale",
+		Yield:         "Dosi",
+		Hours:         "%d h",
+		Minutes:       "%d min",
+		Reviews:       "Recensioni",
+		Rated:         "Valutazione: %s",
+		Ratings:       " su %d valutazioni",
+		OutOf:         "%s su %s",
+		Sponsored:     "Sponsorizzato",
+		ExternalImage: "Immagine esterna",
+		Image:         "Immagine",
+	},
+	"nl": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "Origineel bekijken",
+		ReadFull:      "Lees het volledige artikel",
+		By:            "Door %s",
+		Updated:       "Bijgewerkt %s",
+		Published:     "Gepubliceerd %s",
+		Source:        "Bron: %s",
+		Links:         "Links",
+		Untitled:      "Zonder titel",
+		And:           "en",
+		Today:         "vandaag",
+		JustNow:       "zojuist",
+		In:            "over %s",
+		Ago:           "%s geleden",
+		Units:         [5][2]string{{"minuut", "minuten"}, {"uur", "uur"}, {"dag", "dagen"}, {"maand", "maanden"}, {"jaar", "jaar"}},
+		Ingredients:   "Ingrediënten",
+		Instructions:  "Bereiding",
+		Prep:          "Voorbereiding",
+		Cook:          "Kooktijd",
+		Total:         "Totaal",
+		Yield:         "Porties",
+		Hours:         "%d uur",
+		Minutes:       "%d min",
+		Reviews:       "Beoordelingen",
+		Rated:         "Beoordeeld met %s",
+		Ratings:       " uit %d beoordelingen",
+		OutOf:         "%s van %s",
+		Sponsored:     "Gesponsord",
+		ExternalImage: "Externe afbeelding",
+		Image:         "Afbeelding",
+	},
+	"pt": {
+		Brand:         "Go Reader",
+		ViewOriginal:  "Ver original",
+		ReadFull:      "Ler o artigo completo",
+		By:            "Por %s",
+		Updated:       "Atualizado %s",
+		Published:     "Publicado %s",
+		Source:        "Fonte: %s",
+		Links:         "Links",
+		Untitled:      "Sem título",
+		And:           "e",
+		Today:         "hoje",
+		JustNow:       "agora mesmo",
+		In:            "em %s",
+		Ago:           "há %s",
+		Units:         [5][2]string{{"minuto", "minutos"}, {"hora", "horas"}, {"dia", "dias"}, {"mês", "meses"}, {"ano", "anos"}},
+		Ingredients:   "Ingredientes",
+		Instructions:  "Modo de preparo",
+		Prep:          "Preparo",
+		Cook:          "Cozimento",
+		Total:         "Total",
+		Yield:         "Rendimento",
+		Hours:         "%d h",
+		Minutes:       "%d min",
+		Reviews:       "Avaliações",
+		Rated:         "Avaliado em %s",
+		Ratings:       " de %d avaliações",
+		OutOf:         "%s de %s",
+		Sponsored:     "Patrocinado",
+		ExternalImage: "Imagem externa",
+		Image:         "Imagem",
+	},
+}
+
+// messagesFor returns the UI strings for a language tag such as "fr" or
+// "pt-BR", falling back to the base language and then to English
+func messagesFor(language string) uiMessages {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if messages, ok := uiLocales[language]; ok {
+		return messages
+	}
+	base, _, _ := strings.Cut(language, "-")
+	if messages, ok := uiLocales[base]; ok {
+		return messages
+	}
+	return uiLocales["en"]
+}
+
+// joinNames joins names in the messages' language. Only English uses the
+// serial comma of joinNatural
+func (m uiMessages) joinNames(names []string) string {
+	if m.And == "and" || len(names) < 2 {
+		return joinNatural(names)
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " " + m.And + " " + names[len(names)-1]
+}
+
+// formatAuthor formats authors for display, linking the first name to
+// the author's profile when known
+func formatAuthor(authors []string, profileURL string, messages uiMessages) string {
+	if len(authors) == 0 {
 		return ""
 	}
-	return fmt.Sprintf(`<span class="author">By %s</span>`, html.EscapeString(author))
+	names := make([]string, len(authors))
+	for i, author := range authors {
+		names[i] = html.EscapeString(author)
+	}
+	if profileURL != "" {
+		names[0] = `<a href="` + html.EscapeString(profileURL) + `" rel="author">` + names[0] + `</a>`
+	}
+	return `<span class="author">` + fmt.Sprintf(html.EscapeString(messages.By), messages.joinNames(names)) + `</span>`
 }
 
 // formatPublishDate formats date for display
@@ -399,6 +6841,122 @@ func formatPublishDate(publishDate string) string {
 	return fmt.Sprintf(`<span class="publish-date">%s</span>`, html.EscapeString(publishDate))
 }
 
+// truncateWords cuts content at the block boundary nearest maxWords,
+// reporting whether anything was removed. Single wrapper elements are
+// descended into so the cut falls between paragraphs
+func truncateWords(contentHTML string, maxWords int) (string, bool) {
+	if maxWords <= 0 || len(strings.Fields(extractPlainText(contentHTML))) <= maxWords {
+		return contentHTML, false
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(contentHTML))
+	if err != nil {
+		return contentHTML, false
+	}
+
+	root := doc.Find("body")
+	for {
+		children := root.Children()
+		if children.Length() != 1 || goquery.NodeName(children) == "p" {
+			break
+		}
+		root = children
+	}
+
+	count := 0
+	var cut *goquery.Selection
+	root.Children().EachWithBreak(func(i int, s *goquery.Selection) bool {
+		before := count
+		count += len(strings.Fields(s.Text()))
+		if count < maxWords {
+			return true
+		}
+		// Stop before this block when that lands nearer the limit
+		if count-maxWords > maxWords-before && i > 0 {
+			cut = s
+		} else {
+			cut = s.Next()
+		}
+		return false
+	})
+	if cut == nil || cut.Length() == 0 {
+		return contentHTML, false
+	}
+	cut.NextAll().Remove()
+	cut.Remove()
+
+	truncatedHTML, err := doc.Find("body").Html()
+	if err != nil {
+		return contentHTML, false
+	}
+	return truncatedHTML, true
+}
+
+// relativeTime describes how long before now t was, as in "3 days ago",
+// in the messages' language. Future times within a day are treated as
+// clock skew
+func relativeTime(t, now time.Time, messages uiMessages) string {
+	elapsed := now.Sub(t)
+	future := elapsed < 0
+	if future {
+		if -elapsed < 24*time.Hour {
+			return messages.Today
+		}
+		elapsed = -elapsed
+	}
+
+	var count, unit int
+	switch {
+	case elapsed < time.Minute:
+		return messages.JustNow
+	case elapsed < time.Hour:
+		count, unit = int(elapsed/time.Minute), 0
+	case elapsed < 24*time.Hour:
+		count, unit = int(elapsed/time.Hour), 1
+	case elapsed < 30*24*time.Hour:
+		count, unit = int(elapsed/(24*time.Hour)), 2
+	case elapsed < 365*24*time.Hour:
+		count, unit = int(elapsed/(30*24*time.Hour)), 3
+	default:
+		count, unit = int(elapsed/(365*24*time.Hour)), 4
+	}
+	amount := fmt.Sprintf("%d %s", count, messages.Units[unit][0])
+	if count != 1 {
+		amount = fmt.Sprintf("%d %s", count, messages.Units[unit][1])
+	}
+	if future {
+		return fmt.Sprintf(messages.In, amount)
+	}
+	return fmt.Sprintf(messages.Ago, amount)
+}
+
+// formatModifiedDate formats the last-updated date for display, omitting
+// it when it falls on the publish date
+func formatModifiedDate(publishDate, modifiedDate string, messages uiMessages) string {
+	if modifiedDate == "" || sameDay(publishDate, modifiedDate) {
+		return ""
+	}
+	return `<span class="modified-date">` + html.EscapeString(fmt.Sprintf(messages.Updated, modifiedDate)) + `</span>`
+}
+
+// themeColors maps every color role of a flavour to its RGB triplet
+func themeColors(flavour catppuccin.Flavour) map[string]string {
+	return map[string]string{
+		"rosewater": colorToRGB(flavour.Rosewater()), "flamingo": colorToRGB(flavour.Flamingo()),
+		"pink": colorToRGB(flavour.Pink()), "mauve": colorToRGB(flavour.Mauve()),
+		"red": colorToRGB(flavour.Red()), "maroon": colorToRGB(flavour.Maroon()),
+		"peach": colorToRGB(flavour.Peach()), "yellow": colorToRGB(flavour.Yellow()),
+		"green": colorToRGB(flavour.Green()), "teal": colorToRGB(flavour.Teal()),
+		"sky": colorToRGB(flavour.Sky()), "sapphire": colorToRGB(flavour.Sapphire()),
+		"blue": colorToRGB(flavour.Blue()), "lavender": colorToRGB(flavour.Lavender()),
+		"text": colorToRGB(flavour.Text()), "subtext1": colorToRGB(flavour.Subtext1()),
+		"subtext0": colorToRGB(flavour.Subtext0()), "overlay2": colorToRGB(flavour.Overlay2()),
+		"overlay1": colorToRGB(flavour.Overlay1()), "overlay0": colorToRGB(flavour.Overlay0()),
+		"surface2": colorToRGB(flavour.Surface2()), "surface1": colorToRGB(flavour.Surface1()),
+		"surface0": colorToRGB(flavour.Surface0()), "base": colorToRGB(flavour.Base()),
+		"mantle": colorToRGB(flavour.Mantle()), "crust": colorToRGB(flavour.Crust()),
+	}
+}
+
 // colorToRGB converts catppuccin color to RGB format
 func colorToRGB(color catppuccin.Color) string {
 	return fmt.Sprintf("%d %d %d", color.RGB[0], color.RGB[1], color.RGB[2])
@@ -414,22 +6972,130 @@ func processReaderWASM(this js.Value, args []js.Value) interface{} {
 
 	url := args[0].String()
 
+	config := LoadConfig()
+	if len(args) > 1 {
+		if err := parseOptions(config, args[1]); err != nil {
+			return errorResult(err)
+		}
+	}
+
 	// Process the URL
-	content, err := processURL(url)
+	result, err := processURL(url, config)
 	if err != nil {
-		return map[string]interface{}{
-			"error": err.Error(),
+		return errorResult(err)
+	}
+
+	return toJSValue(result)
+}
+
+// errorResult converts an error into a result map, adding the error code
+// for ReaderErrors
+func errorResult(err error) map[string]interface{} {
+	result := map[string]interface{}{
+		"error": err.Error(),
+	}
+	var readerErr *ReaderError
+	if errors.As(err, &readerErr) {
+		result["code"] = readerErr.Code
+		for key, value := range readerErr.Details {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// processReaderBatchWASM is the WASM entry point for processing several
+// URLs. It returns a Promise resolving to results in input order
+func processReaderBatchWASM(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return errorResult(fmt.Errorf("URL list parameter required"))
+	}
+
+	urls := make([]string, args[0].Length())
+	for i := range urls {
+		urls[i] = args[0].Index(i).String()
+	}
+
+	config := LoadConfig()
+	if len(args) > 1 {
+		if err := parseOptions(config, args[1]); err != nil {
+			return errorResult(err)
 		}
 	}
 
-	return map[string]interface{}{
-		"html": content,
+	// Fetching blocks on the JavaScript event loop, so the work must run in
+	// a goroutine outside this callback
+	executor := js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
+		resolve := promiseArgs[0]
+		go func() {
+			resolve.Invoke(toJSValue(processBatch(urls, config)))
+		}()
+		return nil
+	})
+	defer executor.Release()
+
+	return js.Global().Get("Promise").New(executor)
+}
+
+// processBatch processes URLs with at most config.Concurrency fetches in
+// flight. Results keep the input order and failures do not stop the batch
+func processBatch(urls []string, config *Config) []map[string]interface{} {
+	concurrency := config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]map[string]interface{}, len(urls))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, targetURL := range urls {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, targetURL string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result, err := processURL(targetURL, config)
+			if err != nil {
+				result = errorResult(err)
+			}
+			result["url"] = targetURL
+			results[i] = result
+		}(i, targetURL)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// readerThemeColorsWASM returns the Catppuccin palette used by the reader
+// for a flavor, mapping each color role to its "r g b" string
+func readerThemeColorsWASM(this js.Value, args []js.Value) interface{} {
+	name := ""
+	if len(args) > 0 && args[0].Type() == js.TypeString {
+		name = args[0].String()
+	}
+	return toJSValue(themeColors(flavourByName(name)))
+}
+
+// toJSValue converts a result, including nested structs, into a
+// JavaScript value by round-tripping through JSON
+func toJSValue(result interface{}) interface{} {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("failed to encode result: %v", err),
+		}
 	}
+	return js.Global().Get("JSON").Call("parse", string(encoded))
 }
 
 func main() {
-	// Register the reader function for WASM
+	// Register the reader functions for WASM
 	js.Global().Set("processReader", js.FuncOf(processReaderWASM))
+	js.Global().Set("processReaderBatch", js.FuncOf(processReaderBatchWASM))
+	js.Global().Set("readerThemeColors", js.FuncOf(readerThemeColorsWASM))
 
 	// Keep the program running
 	select {}